@@ -0,0 +1,74 @@
+//go:build linux
+
+package bitcask
+
+import (
+	"os/signal"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// limitFileSize makes writes past size fail with EFBIG after writing what
+// fits, until the returned function is called
+func limitFileSize(t *testing.T, size uint64) func() {
+	var old syscall.Rlimit
+	assert.NoError(t, syscall.Getrlimit(syscall.RLIMIT_FSIZE, &old))
+	signal.Ignore(syscall.SIGXFSZ)
+	limit := old
+	limit.Cur = size
+	assert.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit))
+	return func() {
+		assert.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &old))
+		signal.Reset(syscall.SIGXFSZ)
+	}
+}
+
+func Test_PartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("k0"), []byte("value")))
+
+	// part of the record gets to the file, it is cut off again
+	restore := limitFileSize(t, b.CurrentFile.CurrentPos+10)
+	assert.Error(t, b.Put([]byte("lost"), []byte("value")))
+	restore()
+	assert.NoError(t, b.Put([]byte("k1"), []byte("value")))
+	v, err := b.Get([]byte("k1"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "value", string(v.Value))
+	}
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.Equal(t, 2, b.Len())
+	for _, key := range []string{"k0", "k1"} {
+		v, err := b.Get([]byte(key))
+		if assert.NoError(t, err, key) {
+			assert.Equal(t, "value", string(v.Value))
+		}
+	}
+	_, err = b.Get([]byte("lost"))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_BrokenFile(t *testing.T) {
+	// a file whose partial record cannot be cut off is not appended to again
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("k0"), []byte("value")))
+	b.CurrentFile.broken = true
+	assert.NoError(t, b.Put([]byte("k1"), []byte("value")))
+	assert.Equal(t, []uint32{1, 2}, b.FileIDs())
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.Equal(t, 2, b.Len())
+}
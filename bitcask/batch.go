@@ -0,0 +1,110 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// WriteBatch collects puts and deletes that Bitcask.Write applies
+// atomically: they are appended to the data file as one unit closed by a
+// commit record, and a batch without its commit record is ignored on open.
+type WriteBatch struct {
+	ops  []batchOp
+	size uint64
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+func (wb *WriteBatch) Put(key, value []byte) {
+	wb.ops = append(wb.ops, batchOp{
+		key:   append([]byte(nil), key...),
+		value: append([]byte(nil), value...),
+	})
+	wb.size += RecordSize + uint64(len(key)+len(value))
+}
+
+func (wb *WriteBatch) Delete(key []byte) {
+	wb.ops = append(wb.ops, batchOp{
+		key:    append([]byte(nil), key...),
+		delete: true,
+	})
+	wb.size += RecordSize + uint64(len(key))
+}
+
+// Len returns the number of operations in the batch
+func (wb *WriteBatch) Len() int {
+	return len(wb.ops)
+}
+
+func (wb *WriteBatch) Reset() {
+	wb.ops = wb.ops[:0]
+	wb.size = 0
+}
+
+// Write applies the batch, either all of its operations survive a crash or
+// none of them do.
+func (b *Bitcask) Write(wb *WriteBatch) error {
+	if b.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if wb.Len() == 0 {
+		return nil
+	}
+	seq, err := b.write(wb)
+	if err != nil {
+		return err
+	}
+	return b.waitCommit(seq)
+}
+
+func (b *Bitcask) write(wb *WriteBatch) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosed
+	}
+	commitKey := make([]byte, 4)
+	binary.BigEndian.PutUint32(commitKey, uint32(len(wb.ops)))
+	timeStamp := time.Now().UnixNano()
+	recs := make([]*Record, 0, len(wb.ops)+1)
+	for _, op := range wb.ops {
+		var rec *Record
+		if op.delete {
+			rec = NewRecordWithFlags(timeStamp, op.key, 0, nil, FlagTombstone|FlagBatch)
+		} else {
+			rec = NewRecordWithFlags(timeStamp, op.key, 0, op.value, FlagBatch)
+		}
+		if err := b.checkSize(rec); err != nil {
+			return 0, err
+		}
+		recs = append(recs, rec)
+	}
+	recs = append(recs, NewRecordWithFlags(timeStamp, commitKey, 0, nil, FlagBatchCommit))
+
+	// a batch never spans two data files
+	size := wb.size + RecordSize + uint64(len(commitKey))
+	if FileHeaderSize+size > b.opts.MaxFileSize {
+		return 0, fmt.Errorf("%w: batch of %d bytes, the data file limit is %d", ErrValueTooLarge, size, b.opts.MaxFileSize)
+	}
+	if err := b.rotate(size); err != nil {
+		return 0, err
+	}
+	if err := b.appendRecords(recs); err != nil {
+		return 0, err
+	}
+
+	// the batch is committed, apply it to the memDB
+	for _, rec := range recs[:len(wb.ops)] {
+		b.apply(rec.Entry(b.currentFileID))
+	}
+	return b.afterWrite(int(size))
+}
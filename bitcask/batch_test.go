@@ -0,0 +1,86 @@
+package bitcask
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("old"), []byte("value")))
+
+	wb := NewWriteBatch()
+	for i := 0; i < 10; i++ {
+		wb.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i)))
+	}
+	wb.Put([]byte("key_0"), []byte("overwritten"))
+	wb.Delete([]byte("old"))
+	assert.Equal(t, 12, wb.Len())
+	assert.NoError(t, b.Write(wb))
+
+	check := func(b *Bitcask) {
+		for i := 0; i < 10; i++ {
+			v, err := b.Get([]byte(fmt.Sprintf("key_%d", i)))
+			assert.NoError(t, err)
+			if i == 0 {
+				assert.Equal(t, "overwritten", string(v.Value))
+			} else {
+				assert.Equal(t, fmt.Sprintf("value_%d", i), string(v.Value))
+			}
+		}
+		v, err := b.Get([]byte("old"))
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Nil(t, v)
+	}
+	check(b)
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	check(b)
+	assert.NoError(t, b.Close())
+}
+
+func Test_WriteBatchTorn(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("key"), []byte("value")))
+	// a batch cut short before its commit record
+	ts := time.Now().UnixNano()
+	assert.NoError(t, b.CurrentFile.WriteRecords([]*Record{
+		NewRecordWithFlags(ts, []byte("key"), 0, []byte("torn"), FlagBatch),
+		NewRecordWithFlags(ts, []byte("torn"), 0, []byte("torn"), FlagBatch),
+	}))
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	v, err := b.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(v.Value))
+	v, err = b.Get([]byte("torn"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, v)
+
+	// a committed batch written after the torn one is applied
+	wb := NewWriteBatch()
+	wb.Put([]byte("key"), []byte("batch"))
+	assert.NoError(t, b.Write(wb))
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	v, err = b.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "batch", string(v.Value))
+	v, err = b.Get([]byte("torn"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, v)
+	assert.NoError(t, b.Close())
+}
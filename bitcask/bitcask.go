@@ -1,35 +1,60 @@
 package bitcask
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	MaxFileSize = 1024 * 1024 * 1024 // 1GB
-	RecordSize  = 20
+	// MaxKeySize and MaxValueSize are the default limits on the size of
+	// keys and values, see Options
+	MaxKeySize   = 1<<24 - 1
+	MaxValueSize = MaxFileSize
 )
 
+// Bitcask is safe for concurrent use: Get calls run in parallel, writes are
+// serialized by mu and exclude readers while they update the memDB entries.
 type Bitcask struct {
+	mu            sync.RWMutex
+	mergeMu       sync.Mutex // serializes Merge, which does most of its work without mu
 	Path          string
-	FileIDs       []uint32
-	Files         []*File
+	files         *fileRegistry // every open data file, the current one included
 	currentFileID uint32
 	CurrentFile   *File
 	memDB         *SkipListArr
+	opts          Options
+	lock          *fileLock
+	unsynced      atomic.Uint64 // bytes written since the last sync, for SyncBytes
+	group         *groupCommit  // for SyncGroupCommit
+	stopSync      chan struct{} // stops the SyncInterval loop
+	syncDone      chan struct{}
+	closed        bool // set by Close, guarded by mu
+	recovery      RecoveryReport
+	cache         *valueCache // nil unless Options.CacheSize is set
+	hints         []*Entry    // entries of the current file, its hint once sealed
 }
 
 func ScanDir(path string) ([]uint32, error) {
 	var fileIDs []uint32
 
-	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+	root := filepath.Clean(path)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
+		// only data files directly under path, skip the merge directory
+		if info.IsDir() && path != root {
+			return filepath.SkipDir
+		}
 		// Check if it's a file and has the .data extension
 		if !info.IsDir() && strings.HasSuffix(info.Name(), ".data") {
 			// Extract file ID from filename (e.g., 123.data -> 123)
@@ -47,125 +72,537 @@ func ScanDir(path string) ([]uint32, error) {
 	}
 	return fileIDs, err
 }
+
+// NewBitcask opens the store at path with the default options and panics on
+// failure.
+//
+// Deprecated: use Open, which returns the error instead.
 func NewBitcask(path string) *Bitcask {
+	b, err := Open(path)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Open opens the store at path, creating it unless it is opened read-only,
+// and rebuilds the memDB from the data files.
+func Open(path string, opts ...Option) (*Bitcask, error) {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
 	//scan the directory, get all the file id
 	path = strings.TrimSuffix(path, "/")
-	// if directory is not exist, create it
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		os.MkdirAll(path, os.ModePerm)
+	if options.ReadOnly {
+		if migratePending(path) {
+			return nil, ErrMigratePending
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	} else {
+		// an interrupted Migrate leaves no directory, finish it rather than
+		// create an empty store
+		if err := finishMigrate(path); err != nil {
+			return nil, err
+		}
+		// if directory is not exist, create it
+		if err := os.MkdirAll(path, options.DirMode); err != nil {
+			return nil, err
+		}
 	}
 	path = path + "/"
+	// only one writer at a time, read-only openers share the lock
+	lock, err := acquireLock(path, options.ReadOnly, options.FileMode)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bitcask{
+		Path:  path,
+		files: newFileRegistry(),
+		memDB: NewSkipListArr(),
+		opts:  options,
+		lock:  lock,
+		group: newGroupCommit(),
+		cache: newValueCache(options.CacheSize),
+	}
+	if !options.ReadOnly {
+		// finish or discard a merge interrupted by a crash
+		if err := recoverMerge(path); err != nil {
+			b.Close()
+			return nil, err
+		}
+	} else if pendingMerge(path) {
+		// the data files may be half swapped, only a writer can finish it
+		b.Close()
+		return nil, ErrMergePending
+	}
 	fileIDs, err := ScanDir(path)
 	if err != nil {
-		panic(err)
+		b.Close()
+		return nil, err
 	}
 	// open the file
-	if len(fileIDs) == 0 {
+	if len(fileIDs) == 0 && !options.ReadOnly {
 		// create a new file
 		fileIDs = append(fileIDs, 1)
 	}
-	memdb := NewSkipListArr()
-	b := &Bitcask{
-		Path:    path,
-		FileIDs: fileIDs,
-		memDB:   memdb,
-	}
 	for _, fileID := range fileIDs {
-		file := NewFile(fileID, path)
-		file.OpenFile()
-		b.Files = append(b.Files, file)
+		file := b.newFile(fileID, path)
+		if err := file.OpenFile(); err != nil {
+			b.Close()
+			return nil, err
+		}
+		b.files.add(file)
 	}
 
-	if err != nil {
-		panic(err)
+	if err := b.replay(); err != nil {
+		b.Close()
+		return nil, err
 	}
+	b.Open()
+	if b.CurrentFile != nil && b.CurrentFile.Version != FormatVersion && !options.ReadOnly {
+		// older files are only read, new records go to a new file
+		if err := b.startFile(); err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+	if options.SyncPolicy == SyncInterval && !options.ReadOnly {
+		b.stopSync = make(chan struct{})
+		b.syncDone = make(chan struct{})
+		go b.syncLoop(options.SyncInterval, b.stopSync, b.syncDone)
+	}
+	return b, nil
+}
 
-	for _, file := range b.Files {
+// replay rebuilds the memDB from the data files. Up to Options.ReplayWorkers
+// files are read at once, and applied in id order as they are done so later
+// records win.
+func (b *Bitcask) replay() error {
+	files := b.files.list()
+	results := make([]chan replayResult, len(files))
+	for i := range results {
+		results[i] = make(chan replayResult, 1)
+	}
+	// a slot is taken for each file read and given back once it is applied
+	slots := make(chan struct{}, b.opts.ReplayWorkers)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, file := range files {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func(i int, file *File) {
+				defer wg.Done()
+				entries, err := b.loadFile(file, i < len(files)-1)
+				results[i] <- replayResult{entries, err}
+			}(i, file)
+		}
+	}()
+	// no file may be in use once replay returns
+	defer wg.Wait()
+	defer close(done)
 
-		for {
-			entry, err := file.ReadEntry()
-			if err != nil {
-				break
+	for i, file := range files {
+		r := <-results[i]
+		if r.err != nil {
+			return r.err
+		}
+		sealed := i < len(files)-1
+		b.recovery.tornTail(file, !sealed && !b.opts.ReadOnly)
+		if !sealed && !b.opts.ReadOnly {
+			// appends continue after the last record replayed
+			if err := file.Resume(); err != nil {
+				return err
 			}
-			if e := b.memDB.Search(entry); e != nil {
-				// update the entry
-				if e.ValueSize == 0 {
-					// delete the entry
-					b.memDB.Delete(e)
-				}
-				e.ValuePos = entry.ValuePos
-				e.TimeStamp = entry.TimeStamp
-				e.ValueSize = entry.ValueSize
-			} else {
-				b.memDB.Insert(entry)
+			// the memDB takes the entries, the hint keeps copies
+			for _, entry := range r.entries {
+				e := *entry
+				b.hints = append(b.hints, &e)
 			}
 		}
+		for _, entry := range r.entries {
+			b.apply(entry)
+		}
+		b.recovery.Records += len(r.entries)
+		<-slots
 	}
-	return b
+	return nil
+}
+
+type replayResult struct {
+	entries []*Entry
+	err     error
 }
 
+// loadFile reads the entries of file for replay. The hint file of a sealed
+// file is used when there is a valid one, otherwise the data file is scanned
+// and a hint is written for the next time.
+func (b *Bitcask) loadFile(file *File, sealed bool) ([]*Entry, error) {
+	if sealed && b.opts.Mmap {
+		// failing to map is not an error, the file is read as usual
+		file.Map()
+	}
+	if sealed {
+		if entries, err := ReadHintFile(b.Path, file.FileID); err == nil {
+			return entries, nil
+		}
+	}
+	entries, err := file.ReadEntries()
+	if err != nil {
+		return nil, err
+	}
+	if sealed && !b.opts.ReadOnly {
+		// the hint is only an optimisation, ignore failures
+		WriteHintFile(b.Path, file.FileID, entries, b.opts.FileMode)
+	}
+	return entries, nil
+}
+
+// Open sets the last data file as the current file. Open (the function)
+// already does this, it is kept for callers of NewBitcask.
 func (b *Bitcask) Open() error {
-	b.CurrentFile = b.Files[len(b.Files)-1]
-	b.currentFileID = b.FileIDs[len(b.FileIDs)-1]
+	last := b.files.last()
+	if last == nil {
+		return nil
+	}
+	b.CurrentFile = last
+	b.currentFileID = last.FileID
 	return nil
 }
 
+// FileIDs returns the ids of the data files in order, the last one is the
+// current file
+func (b *Bitcask) FileIDs() []uint32 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.files.IDs()
+}
+
+// newFile creates a File using the permissions from the options
+func (b *Bitcask) newFile(fileID uint32, path string) *File {
+	f := NewFile(fileID, path)
+	f.Mode = b.opts.FileMode
+	f.ReadOnly = b.opts.ReadOnly
+	f.MaxKeySize = b.opts.MaxKeySize
+	f.MaxValueSize = b.opts.MaxValueSize
+	return f
+}
+
+// Close closes the data files and releases the directory lock, every call
+// after the first returns ErrClosed
 func (b *Bitcask) Close() error {
+	// wait for a merge, it reads the sealed files without mu
+	b.mergeMu.Lock()
+	defer b.mergeMu.Unlock()
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.closed = true
+	stop, done := b.stopSync, b.syncDone
+	b.stopSync = nil
+	b.mu.Unlock()
+	// only the call that closed the store stops the sync loop, outside mu as
+	// the loop takes it
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	for _, file := range b.Files {
-		if err := file.CloseFile(); err != nil {
-			return err
+	// flush what the sync policy may have left behind. The files are closed
+	// and the lock released whatever fails, the first error is returned.
+	var err error
+	if b.CurrentFile != nil && b.CurrentFile.Fd != nil && !b.opts.ReadOnly {
+		err = b.CurrentFile.Sync()
+	}
+	for _, file := range b.files.list() {
+		if file.Fd == nil {
+			continue
+		}
+		if err2 := file.CloseFile(); err == nil {
+			err = err2
 		}
 	}
-	return nil
+	if err2 := b.lock.release(); err == nil {
+		err = err2
+	}
+	b.lock = nil
+	return err
 }
 
 func (b *Bitcask) Put(key []byte, value []byte) error {
-	// write the record to the file
-	// check the size of the file, if it's full, create a new file
-	if b.CurrentFile.CurrentPos+uint32(len(key)+len(value))+20 > MaxFileSize {
-		// create a new file
-		b.currentFileID++
-		file := NewFile(b.currentFileID, b.Path)
-		b.FileIDs = append(b.FileIDs, b.currentFileID)
-		file.OpenFile()
-		b.CurrentFile = file
+	if b.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	seq, err := b.put(key, value, 0)
+	if err != nil {
+		return err
+	}
+	return b.waitCommit(seq)
+}
+
+// PutWithTTL stores value under key until ttl has passed, after that the key
+// reads as missing and is dropped by replay and merge. Expiry is kept in
+// whole seconds rounded up, so the key may outlive ttl by up to a second.
+func (b *Bitcask) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if b.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("bitcask: ttl must be positive, got %s", ttl)
 	}
-	record, err := b.CurrentFile.WriteRecord(key, value)
+	seq, err := b.put(key, value, expiryAt(now().Add(ttl)))
 	if err != nil {
 		return err
 	}
+	return b.waitCommit(seq)
+}
+
+func (b *Bitcask) put(key []byte, value []byte, expiry int64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosed
+	}
+	record := newRecord(time.Now().UnixNano(), key, 0, value, 0, expiry)
+	if err := b.checkSize(record); err != nil {
+		return 0, err
+	}
+	size := record.HeaderSize() + uint64(record.KeySize) + record.ValueSize
+	// write the record to the file
+	if err := b.rotate(size); err != nil {
+		return 0, err
+	}
+	if err := b.appendRecords([]*Record{record}); err != nil {
+		return 0, err
+	}
 	// insert the entry into the memDB
-	entry := NewEntry(key, b.currentFileID, record.ValueSize, record.ValuePos, record.TimeStamp)
-	// first search in the memDB
-	if e := b.memDB.Search(entry); e != nil {
-		// update the entry
-		e.ValueSize = record.ValueSize
-		e.ValuePos = record.ValuePos
-		e.TimeStamp = record.TimeStamp
-		if e.ValueSize == 0 {
+	b.apply(record.Entry(b.currentFileID))
+	return b.afterWrite(int(size))
+}
+
+// Delete writes a tombstone for key and removes it from the memDB.
+// Deleting a key that does not exist is a no-op.
+func (b *Bitcask) Delete(key []byte) error {
+	if b.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	seq, err := b.delete(key)
+	if err != nil {
+		return err
+	}
+	return b.waitCommit(seq)
+}
+
+func (b *Bitcask) delete(key []byte) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosed
+	}
+	e := b.memDB.Search(NewTmpEntry(key))
+	if e == nil {
+		return 0, nil
+	}
+	if err := b.rotate(RecordSize + uint64(len(key))); err != nil {
+		return 0, err
+	}
+	if err := b.appendRecords([]*Record{NewTombstone(time.Now().UnixNano(), key, 0)}); err != nil {
+		return 0, err
+	}
+	b.memDB.Delete(e)
+	b.cache.remove(e.FileID, e.ValuePos)
+	return b.afterWrite(RecordSize + len(key))
+}
+
+// apply updates the memDB with an entry read or written in file order: a
+// tombstone or an expired entry removes the key, anything else replaces the
+// previous location of the key. mu must be held for writing.
+func (b *Bitcask) apply(entry *Entry) {
+	e := b.memDB.Search(entry)
+	if e != nil {
+		// the old record is not read again
+		b.cache.remove(e.FileID, e.ValuePos)
+	}
+	if entry.IsTombstone() || entry.Expired(now()) {
+		if e != nil {
 			// delete the entry
 			b.memDB.Delete(e)
 		}
-	} else {
+		return
+	}
+	if e == nil {
 		b.memDB.Insert(entry)
+		return
+	}
+	// update the entry
+	e.FileID = entry.FileID
+	e.ValueSize = entry.ValueSize
+	e.ValuePos = entry.ValuePos
+	e.TimeStamp = entry.TimeStamp
+	e.Flags = entry.Flags
+	e.Expiry = entry.Expiry
+}
+
+// checkSize returns ErrKeyTooLarge or ErrValueTooLarge if rec cannot be
+// written. A record never spans two data files so it has to fit in one.
+func (b *Bitcask) checkSize(rec *Record) error {
+	if uint64(len(rec.Key)) > uint64(b.opts.MaxKeySize) {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrKeyTooLarge, len(rec.Key), b.opts.MaxKeySize)
+	}
+	if uint64(len(rec.Value)) > b.opts.MaxValueSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrValueTooLarge, len(rec.Value), b.opts.MaxValueSize)
+	}
+	size := rec.HeaderSize() + uint64(len(rec.Key)) + uint64(len(rec.Value))
+	if FileHeaderSize+size > b.opts.MaxFileSize {
+		return fmt.Errorf("%w: record of %d bytes, the data file limit is %d", ErrValueTooLarge, size, b.opts.MaxFileSize)
 	}
-	b.CurrentFile.Sync()
 	return nil
 }
 
+// rotate checks the size of the current file, if size more bytes do not fit,
+// or a failed write broke it, create a new file
+func (b *Bitcask) rotate(size uint64) error {
+	if !b.CurrentFile.broken && b.CurrentFile.CurrentPos+size <= b.opts.MaxFileSize {
+		return nil
+	}
+	return b.startFile()
+}
+
+// appendRecords writes recs to the current file and keeps their entries for
+// its hint, without the batch commit records as ReadEntries does. mu must be
+// held for writing.
+func (b *Bitcask) appendRecords(recs []*Record) error {
+	if err := b.CurrentFile.WriteRecords(recs); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if rec.Flags&FlagBatchCommit == 0 {
+			b.hints = append(b.hints, rec.Entry(b.currentFileID))
+		}
+	}
+	return nil
+}
+
+// startFile seals the current file and makes a new one current. If the new
+// file cannot be created the current one stays as it was.
+func (b *Bitcask) startFile() error {
+	sealed := b.CurrentFile
+	if err := sealed.Sync(); err != nil {
+		return err
+	}
+	// create a new file
+	file := b.newFile(b.currentFileID+1, b.Path)
+	if err := file.OpenFile(); err != nil {
+		return err
+	}
+	b.currentFileID++
+	b.files.add(file)
+	b.CurrentFile = file
+	// nothing is appended to the old file any more, it can be mapped
+	if b.opts.Mmap {
+		sealed.Map()
+	}
+
+	// seal the old file with a hint file, it is only an optimisation for
+	// the next startup so failures are ignored. A broken file ends in a
+	// partial record, replay reads it without a hint.
+	if !sealed.broken {
+		WriteHintFile(b.Path, sealed.FileID, b.hints, b.opts.FileMode)
+	}
+	b.hints = nil
+	return nil
+}
+
+// Get returns the record of key, or ErrNotFound if there is none
 func (b *Bitcask) Get(key []byte) (*Record, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
 	tmp := NewTmpEntry(key)
 	entry := b.memDB.Search(tmp)
-	if entry != nil {
-		// read the value from the file
-		f := b.Files[entry.FileID-1]
+	if entry == nil || entry.Expired(now()) {
+		return nil, ErrNotFound
+	}
+	// read the record from the file
+	return b.readRecord(entry, true)
+}
+
+// GetNoCopy is Get without copying the record when it is in a sealed data
+// file mapped with WithMmap: its Key and Value point into the mapping, must
+// not be modified and are only valid until release is called. release must
+// be called once done with the record, even after Close. Records of the
+// current file are copied as with Get.
+func (b *Bitcask) GetNoCopy(key []byte) (rec *Record, release func(), err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, nil, ErrClosed
+	}
+	entry := b.memDB.Search(NewTmpEntry(key))
+	if entry == nil || entry.Expired(now()) {
+		return nil, nil, ErrNotFound
+	}
+	f := b.files.get(entry.FileID)
+	if f == nil {
+		return nil, nil, &FileError{FileID: entry.FileID, Offset: int64(entry.ValuePos), Err: os.ErrNotExist}
+	}
+	if f.mmap == nil {
+		rec, err := b.readRecord(entry, true)
+		return rec, func() {}, err
+	}
+	return f.ViewRecord(entry, !b.opts.SkipChecksum)
+}
+
+// readRecord reads the record of entry from the cache or from its data file,
+// checking it unless SkipChecksum is set. fill adds a record read from the
+// file to the cache, scans leave it out so they do not evict what Get reads
+// often. mu must be held.
+func (b *Bitcask) readRecord(entry *Entry, fill bool) (*Record, error) {
+	if rec := b.cache.get(entry.FileID, entry.ValuePos); rec != nil {
+		return rec, nil
+	}
+	f := b.files.get(entry.FileID)
+	if f == nil {
+		return nil, &FileError{FileID: entry.FileID, Offset: int64(entry.ValuePos), Err: os.ErrNotExist}
+	}
+	var rec *Record
+	if !b.opts.SkipChecksum {
+		var err error
+		if rec, err = f.ReadRecord(entry); err != nil {
+			return nil, err
+		}
+	} else {
 		value, err := f.Read(entry.ValuePos, entry.ValueSize)
 		if err != nil {
 			return nil, err
 		}
-		rec := NewRecord(entry.TimeStamp, entry.Key, entry.ValuePos, value)
-		return rec, nil
+		rec = newRecord(entry.TimeStamp, entry.Key, entry.ValuePos, value, entry.Flags, entry.Expiry)
+	}
+	if fill {
+		b.cache.add(entry.FileID, entry.ValuePos, rec)
 	}
-	return nil, nil
+	return rec, nil
+}
+
+// CacheStats returns the counters of the value cache, all zero without one
+func (b *Bitcask) CacheStats() CacheStats {
+	return b.cache.stats()
 }
@@ -2,6 +2,10 @@ package bitcask
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
 	"testing"
 )
 
@@ -79,3 +83,160 @@ func Benchmark_PutAndGetProperty(b *testing.B) {
 		}
 	}
 }
+
+func benchmarkPutParallel(b *testing.B, opts ...Option) {
+	bitcask, err := Open(b.TempDir(), opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bitcask.Close()
+
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			key := []byte(fmt.Sprintf("key_%d", i))
+			value := []byte(fmt.Sprintf("value_%0122d", i))
+			if err := bitcask.Put(key, value); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func Benchmark_PutParallelSyncAlways(b *testing.B) {
+	benchmarkPutParallel(b, WithSyncPolicy(SyncAlways))
+}
+
+func Benchmark_PutParallelGroupCommit(b *testing.B) {
+	benchmarkPutParallel(b, WithGroupCommit())
+}
+
+func Benchmark_PutParallelSyncNever(b *testing.B) {
+	benchmarkPutParallel(b, WithSyncPolicy(SyncNever))
+}
+
+func benchmarkGet(b *testing.B, opts ...Option) {
+	bitcask, err := Open(b.TempDir(), append(opts, WithSyncPolicy(SyncNever))...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bitcask.Close()
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		value := []byte(fmt.Sprintf("value_%0122d", i))
+		if err := bitcask.Put(key, value); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i%10000))
+		if _, err := bitcask.Get(key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_GetVerifyChecksum(b *testing.B) {
+	benchmarkGet(b)
+}
+
+func Benchmark_GetSkipChecksum(b *testing.B) {
+	benchmarkGet(b, WithSkipChecksum(true))
+}
+
+func Benchmark_GetCached(b *testing.B) {
+	benchmarkGet(b, WithCacheSize(64<<20))
+}
+
+// benchmarkGetSealed reads records from sealed 1MB files, with GetNoCopy
+// when noCopy is set
+func benchmarkGetSealed(b *testing.B, noCopy bool, opts ...Option) {
+	opts = append(opts, WithMaxFileSize(1<<20), WithSyncPolicy(SyncNever))
+	bitcask, err := Open(b.TempDir(), opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bitcask.Close()
+	for i := 0; i < 100000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		value := []byte(fmt.Sprintf("value_%01018d", i))
+		if err := bitcask.Put(key, value); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i%90000))
+		if noCopy {
+			_, release, err := bitcask.GetNoCopy(key)
+			if err != nil {
+				b.Fatal(err)
+			}
+			release()
+		} else if _, err := bitcask.Get(key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_GetSealedPread(b *testing.B) {
+	benchmarkGetSealed(b, false)
+}
+
+func Benchmark_GetSealedMmap(b *testing.B) {
+	benchmarkGetSealed(b, false, WithMmap(true))
+}
+
+func Benchmark_GetSealedMmapNoCopy(b *testing.B) {
+	benchmarkGetSealed(b, true, WithMmap(true))
+}
+
+func Benchmark_GetSealedMmapNoCopySkipChecksum(b *testing.B) {
+	benchmarkGetSealed(b, true, WithMmap(true), WithSkipChecksum(true))
+}
+
+func benchmarkOpen(b *testing.B, workers int) {
+	dir := b.TempDir()
+	bitcask, err := Open(dir, WithMaxFileSize(1<<20), WithSyncPolicy(SyncNever))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 200000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		value := []byte(fmt.Sprintf("value_%0122d", i))
+		if err := bitcask.Put(key, value); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := bitcask.Close(); err != nil {
+		b.Fatal(err)
+	}
+	// scan the data files rather than read their hints
+	hints, _ := filepath.Glob(dir + "/*.hint")
+	for _, name := range hints {
+		os.Remove(name)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bitcask, err := Open(dir, WithReplayWorkers(workers), WithReadOnly(true))
+		if err != nil {
+			b.Fatal(err)
+		}
+		bitcask.Close()
+	}
+}
+
+func Benchmark_OpenSequential(b *testing.B) {
+	benchmarkOpen(b, 1)
+}
+
+func Benchmark_OpenParallel(b *testing.B) {
+	benchmarkOpen(b, runtime.GOMAXPROCS(0))
+}
@@ -1,12 +1,18 @@
 package bitcask
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_Bitcask(t *testing.T) {
-	b := NewBitcask("/tmp/")
+	b := NewBitcask(t.TempDir())
 	b.Open()
 	b.Put([]byte("key"), []byte("value"))
 	b.Put([]byte("key"), []byte("value2"))
@@ -14,13 +20,428 @@ func Test_Bitcask(t *testing.T) {
 }
 
 func Test_ScanDir(t *testing.T) {
-	b := NewBitcask("/tmp/")
+	dir := t.TempDir()
+	b := NewBitcask(dir)
+	b.Open()
+	b.Put([]byte("key"), []byte("value"))
+	b.Put([]byte("key"), []byte("value2"))
+	b.Close()
+
+	b = NewBitcask(dir)
 	b.Open()
 	key := []byte("key")
 	v, _ := b.Get(key)
 	fmt.Println(string(v.Key))
 	fmt.Println(v.ValuePos)
 	fmt.Println(string(v.Value))
-	//b.Put([]byte("key"), []byte("value2"))
+	assert.Equal(t, "value2", string(v.Value))
+	b.Close()
+}
+
+func Test_Delete(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBitcask(dir)
+	b.Open()
+	assert.NoError(t, b.Put([]byte("key1"), []byte("value1")))
+	assert.NoError(t, b.Put([]byte("key2"), []byte("value2")))
+	assert.NoError(t, b.Delete([]byte("key1")))
+	// deleting a missing key is a no-op
+	assert.NoError(t, b.Delete([]byte("missing")))
+	v, err := b.Get([]byte("key1"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, v)
+	b.Close()
+
+	// the deletion survives a restart
+	b = NewBitcask(dir)
+	b.Open()
+	v, err = b.Get([]byte("key1"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, v)
+	v, err = b.Get([]byte("key2"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value2", string(v.Value))
+	b.Close()
+}
+
+func Test_EmptyValue(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBitcask(dir)
+	b.Open()
+	assert.NoError(t, b.Put([]byte("key"), []byte{}))
+	v, err := b.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.NotNil(t, v)
+	assert.Empty(t, v.Value)
+	b.Close()
+
+	// an empty value is not a deletion
+	b = NewBitcask(dir)
+	b.Open()
+	v, err = b.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.NotNil(t, v)
+	assert.Empty(t, v.Value)
 	b.Close()
 }
+
+func Test_RestartAndContinue(t *testing.T) {
+	dir := t.TempDir()
+	want := map[string]string{}
+	check := func(b *Bitcask) {
+		for k, v := range want {
+			rec, err := b.Get([]byte(k))
+			if assert.NoError(t, err, k) {
+				assert.Equal(t, v, string(rec.Value), k)
+			}
+		}
+	}
+	for round := 0; round < 5; round++ {
+		b, err := Open(dir, WithMaxFileSize(512), WithSyncPolicy(SyncNever))
+		assert.NoError(t, err)
+		check(b)
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key_%d_%d", round, i)
+			want[key] = fmt.Sprintf("value_%d_%d", round, i)
+			assert.NoError(t, b.Put([]byte(key), []byte(want[key])))
+		}
+		// overwrite a key from the previous round
+		if round > 0 {
+			key := fmt.Sprintf("key_%d_0", round-1)
+			want[key] = "updated"
+			assert.NoError(t, b.Put([]byte(key), []byte("updated")))
+		}
+		check(b)
+		assert.NoError(t, b.Close())
+	}
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	check(b)
+	assert.NoError(t, b.Close())
+}
+
+func Test_RestartAfterTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("key1"), []byte("value1")))
+	assert.NoError(t, b.Close())
+
+	// a crash in the middle of a write leaves part of a record behind
+	torn := NewRecord(1, []byte("torn"), 0, []byte("value")).Encode()
+	fd, err := os.OpenFile(dir+"/1.data", os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = fd.Write(torn[:RecordSize+2])
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	offset := uint64(FileHeaderSize + RecordSize + 4 + 6)
+	assert.Equal(t, RecoveryReport{
+		Records:   1,
+		Discarded: RecordSize + 2,
+		Corrupt:   []CorruptRange{{FileID: 1, Offset: offset, Size: RecordSize + 2}},
+	}, b.Recovery())
+	assert.NoError(t, b.Put([]byte("key2"), []byte("value2")))
+	for _, k := range []string{"key1", "key2"} {
+		v, err := b.Get([]byte(k))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "value"+k[3:], string(v.Value))
+		}
+	}
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.Equal(t, RecoveryReport{Records: 2}, b.Recovery())
+	assert.Len(t, b.Keys(), 2)
+	v, err := b.Get([]byte("key2"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "value2", string(v.Value))
+	}
+}
+
+func Test_OpenOptions(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir, WithMaxFileSize(256), WithSyncPolicy(SyncNever), WithFileMode(0600))
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	// small files rotate often
+	assert.Greater(t, len(b.FileIDs()), 1)
+	for i := 0; i < 20; i++ {
+		v, err := b.Get([]byte(fmt.Sprintf("key_%d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value_%d", i), string(v.Value))
+	}
+	info, err := b.CurrentFile.Stat()
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir, WithReadOnly(true))
+	assert.NoError(t, err)
+	v, err := b.Get([]byte("key_19"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value_19", string(v.Value))
+	assert.ErrorIs(t, b.Put([]byte("key"), []byte("value")), ErrReadOnly)
+	assert.ErrorIs(t, b.Delete([]byte("key_1")), ErrReadOnly)
+	assert.NoError(t, b.Close())
+}
+
+func Test_OpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	// a read-only store is never created
+	_, err := Open(dir+"/missing", WithReadOnly(true))
+	assert.Error(t, err)
+	_, err = os.Stat(dir + "/missing")
+	assert.True(t, os.IsNotExist(err))
+
+	// the path is a regular file
+	assert.NoError(t, os.WriteFile(dir+"/file", []byte("x"), 0644))
+	_, err = Open(dir + "/file")
+	assert.Error(t, err)
+}
+
+func Test_Errors(t *testing.T) {
+	b, err := Open(t.TempDir(), WithMaxFileSize(64))
+	assert.NoError(t, err)
+	_, err = b.Get([]byte("missing"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, b.Put(make([]byte, MaxKeySize+1), nil), ErrKeyTooLarge)
+	assert.ErrorIs(t, b.Put([]byte("key"), make([]byte, 64)), ErrValueTooLarge)
+	wb := NewWriteBatch()
+	wb.Put([]byte("key1"), make([]byte, 20))
+	wb.Put([]byte("key2"), make([]byte, 20))
+	assert.ErrorIs(t, b.Write(wb), ErrValueTooLarge)
+	assert.Empty(t, b.Keys())
+	assert.NoError(t, b.Put([]byte("key"), []byte("value")))
+
+	assert.NoError(t, b.Close())
+	assert.ErrorIs(t, b.Close(), ErrClosed)
+	_, err = b.Get([]byte("key"))
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, b.Put([]byte("key"), []byte("value")), ErrClosed)
+	assert.ErrorIs(t, b.Delete([]byte("key")), ErrClosed)
+	assert.ErrorIs(t, b.Write(wb), ErrClosed)
+	assert.ErrorIs(t, b.Sync(), ErrClosed)
+	assert.ErrorIs(t, b.Merge(), ErrClosed)
+	assert.ErrorIs(t, b.Fold(func(key, value []byte) error { return nil }), ErrClosed)
+}
+
+func Test_GetChecksum(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir, WithSyncPolicy(SyncNever))
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.NoError(t, b.Put([]byte("key1"), []byte("value1")))
+	assert.NoError(t, b.Put([]byte("key2"), []byte("value2")))
+	v, err := b.Get([]byte("key2"))
+	assert.NoError(t, err)
+	assert.Equal(t, v.Crc, checksum(FormatVersion, 0, 0, []byte("key2"), []byte("value2")))
+
+	// rot a byte of the second value behind the store's back
+	fd, err := os.OpenFile(b.CurrentFile.Name(), os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = fd.WriteAt([]byte("X"), int64(v.ValuePos))
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+
+	_, err = b.Get([]byte("key2"))
+	assert.ErrorIs(t, err, ErrChecksum)
+	var fileErr *FileError
+	if assert.ErrorAs(t, err, &fileErr) {
+		assert.Equal(t, uint32(1), fileErr.FileID)
+		assert.Equal(t, int64(FileHeaderSize+RecordSize+4+6), fileErr.Offset)
+	}
+	assert.ErrorIs(t, b.Fold(func(key, value []byte) error { return nil }), ErrChecksum)
+	v, err = b.Get([]byte("key1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value1", string(v.Value))
+
+	// an entry pointing at the wrong record is caught by the key check
+	e := b.memDB.Search(NewTmpEntry([]byte("key1")))
+	e.ValuePos += RecordSize + 4 + 6
+	_, err = b.Get([]byte("key1"))
+	assert.ErrorIs(t, err, ErrChecksum)
+	e.ValuePos -= RecordSize + 4 + 6
+
+	// the corruption goes unnoticed when the check is skipped
+	b.opts.SkipChecksum = true
+	v, err = b.Get([]byte("key2"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Xalue2", string(v.Value))
+}
+
+func Test_Concurrent(t *testing.T) {
+	b, err := Open(t.TempDir(), WithMaxFileSize(4096), WithSyncPolicy(SyncNever))
+	assert.NoError(t, err)
+	defer b.Close()
+
+	const writers, readers, keys = 4, 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := []byte(fmt.Sprintf("key_%d_%d", w, i))
+				assert.NoError(t, b.Put(key, []byte(fmt.Sprintf("value_%d", i))))
+				if i%10 == 0 {
+					assert.NoError(t, b.Delete(key))
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				v, err := b.Get([]byte(fmt.Sprintf("key_%d_%d", r%writers, i)))
+				if !errors.Is(err, ErrNotFound) && assert.NoError(t, err) {
+					assert.Equal(t, fmt.Sprintf("value_%d", i), string(v.Value))
+				}
+			}
+		}(r)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, b.Merge())
+		}
+	}()
+	wg.Wait()
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < keys; i++ {
+			v, err := b.Get([]byte(fmt.Sprintf("key_%d_%d", w, i)))
+			if i%10 == 0 {
+				assert.ErrorIs(t, err, ErrNotFound)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, fmt.Sprintf("value_%d", i), string(v.Value))
+			}
+		}
+	}
+}
+
+// dirState returns the names and sizes of the files in dir
+func dirState(t *testing.T, dir string) map[string]int64 {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	state := map[string]int64{}
+	for _, e := range entries {
+		info, err := e.Info()
+		assert.NoError(t, err)
+		state[e.Name()] = info.Size()
+	}
+	return state
+}
+
+func Test_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("key1"), []byte("value1")))
+	b.rotate(MaxFileSize)
+	assert.NoError(t, b.Put([]byte("key2"), []byte("value2")))
+	assert.NoError(t, b.Close())
+	// a torn record at the end of the current file and no hint for the
+	// sealed one, a writer would truncate and rewrite them
+	assert.NoError(t, RemoveHintFile(b.Path, 1))
+	fd, err := os.OpenFile(NewFile(2, b.Path).Name(), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = fd.Write(NewRecord(0, []byte("key3"), 0, []byte("value3")).Encode()[:27])
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+	before := dirState(t, dir)
+
+	b, err = Open(dir, WithReadOnly(true))
+	assert.NoError(t, err)
+	v, err := b.Get([]byte("key1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value1", string(v.Value))
+	v, err = b.Get([]byte("key2"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value2", string(v.Value))
+	assert.ErrorIs(t, b.Put([]byte("key"), []byte("value")), ErrReadOnly)
+	assert.ErrorIs(t, b.PutWithTTL([]byte("key"), []byte("value"), time.Minute), ErrReadOnly)
+	assert.ErrorIs(t, b.Delete([]byte("key1")), ErrReadOnly)
+	wb := NewWriteBatch()
+	wb.Put([]byte("key"), []byte("value"))
+	assert.ErrorIs(t, b.Write(wb), ErrReadOnly)
+	assert.ErrorIs(t, b.Merge(), ErrReadOnly)
+	assert.NoError(t, b.Sync())
+	assert.NoError(t, b.Close())
+
+	assert.Equal(t, before, dirState(t, dir))
+}
+
+func Test_ReadOnlyMergePending(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("key"), []byte("value")))
+	assert.NoError(t, b.Close())
+	mergePath := b.Path + mergeDirName + "/"
+	assert.NoError(t, os.MkdirAll(mergePath, os.ModePerm))
+	assert.NoError(t, writeMergeFin(mergePath, 1, 0, 0644))
+
+	_, err = Open(dir, WithReadOnly(true))
+	assert.ErrorIs(t, err, ErrMergePending)
+}
+
+func Test_ParallelReplay(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir, WithMaxFileSize(512), WithSyncPolicy(SyncNever))
+	assert.NoError(t, err)
+	want := map[string]string{}
+	// keys are overwritten and deleted across many files
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("key_%d", i%37)
+		switch {
+		case i%11 == 0:
+			assert.NoError(t, b.Delete([]byte(key)))
+			delete(want, key)
+		default:
+			want[key] = fmt.Sprintf("value_%d", i)
+			assert.NoError(t, b.Put([]byte(key), []byte(want[key])))
+		}
+	}
+	fileIDs := b.FileIDs()
+	assert.Greater(t, len(fileIDs), 20)
+	assert.NoError(t, b.Close())
+	// some files are read from their hint, the others are scanned
+	for _, id := range fileIDs[:len(fileIDs)/2] {
+		assert.NoError(t, RemoveHintFile(dir+"/", id))
+	}
+
+	for _, workers := range []int{1, 3, 16} {
+		b, err := Open(dir, WithReplayWorkers(workers), WithReadOnly(true))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, len(want), b.Len(), "workers %d", workers)
+		for k, v := range want {
+			rec, err := b.Get([]byte(k))
+			if assert.NoError(t, err, k) {
+				assert.Equal(t, v, string(rec.Value), k)
+			}
+		}
+		assert.NoError(t, b.Close())
+	}
+
+	// a corrupt file stops the replay, whatever is still being read
+	data, err := os.ReadFile(fmt.Sprintf("%s/%d.data", dir, fileIDs[1]))
+	assert.NoError(t, err)
+	data[FileHeaderSize+RecordSize] ^= 0xff
+	assert.NoError(t, os.WriteFile(fmt.Sprintf("%s/%d.data", dir, fileIDs[1]), data, 0644))
+	_, err = Open(dir, WithReplayWorkers(4))
+	assert.ErrorIs(t, err, ErrChecksum)
+	_, err = Open(dir, WithReplayWorkers(0))
+	assert.Error(t, err)
+}
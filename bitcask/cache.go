@@ -0,0 +1,153 @@
+package bitcask
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// cacheOverhead is what a cached record costs besides its key and value
+const cacheOverhead = 128
+
+// CacheStats are the counters of the value cache, see WithCacheSize
+type CacheStats struct {
+	Hits    uint64 // reads served from the cache
+	Misses  uint64 // reads that went to the data file
+	Size    uint64 // bytes used, keys and values plus a fixed overhead per record
+	Entries int    // records cached
+}
+
+// valueCache is an LRU cache of the records read by Get bounded in bytes. It
+// is keyed by where the value is in the data files: a location always holds
+// the same record, except in files a merge replaced, which are dropped with
+// removeFile. A nil cache caches nothing.
+type valueCache struct {
+	mu      sync.Mutex
+	maxSize uint64
+	size    uint64
+	lru     *list.List // front is the most recently used
+	items   map[cacheKey]*list.Element
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+type cacheKey struct {
+	fileID uint32
+	pos    uint64
+}
+
+type cacheItem struct {
+	key cacheKey
+	rec *Record
+}
+
+func newValueCache(maxSize uint64) *valueCache {
+	if maxSize == 0 {
+		return nil
+	}
+	return &valueCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   make(map[cacheKey]*list.Element),
+	}
+}
+
+func recordCost(rec *Record) uint64 {
+	return uint64(len(rec.Key)) + uint64(len(rec.Value)) + cacheOverhead
+}
+
+// copyRecord copies rec with its key and value, records in the cache are
+// never handed out
+func copyRecord(rec *Record) *Record {
+	r := *rec
+	r.Key = append([]byte(nil), rec.Key...)
+	r.Value = append(make([]byte, 0, len(rec.Value)), rec.Value...)
+	return &r
+}
+
+// get returns a copy of the record with its value at pos in file fileID, nil
+// if it is not cached
+func (c *valueCache) get(fileID uint32, pos uint64) *Record {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	el, ok := c.items[cacheKey{fileID, pos}]
+	if !ok {
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	rec := el.Value.(*cacheItem).rec
+	c.mu.Unlock()
+	c.hits.Add(1)
+	return copyRecord(rec)
+}
+
+// add caches a copy of rec, the record with its value at pos in file fileID,
+// evicting the least recently used records to make room
+func (c *valueCache) add(fileID uint32, pos uint64, rec *Record) {
+	if c == nil || recordCost(rec) > c.maxSize {
+		return
+	}
+	key := cacheKey{fileID, pos}
+	rec = copyRecord(rec)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = c.lru.PushFront(&cacheItem{key: key, rec: rec})
+	c.size += recordCost(rec)
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// remove drops the record with its value at pos in file fileID
+func (c *valueCache) remove(fileID uint32, pos uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[cacheKey{fileID, pos}]; ok {
+		c.removeElement(el)
+	}
+}
+
+// removeFile drops the records of file fileID
+func (c *valueCache) removeFile(fileID uint32) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.items {
+		if key.fileID == fileID {
+			c.removeElement(el)
+		}
+	}
+}
+
+// removeElement drops el, mu must be held
+func (c *valueCache) removeElement(el *list.Element) {
+	item := c.lru.Remove(el).(*cacheItem)
+	delete(c.items, item.key)
+	c.size -= recordCost(item.rec)
+}
+
+func (c *valueCache) stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Size:    c.size,
+		Entries: len(c.items),
+	}
+}
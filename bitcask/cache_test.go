@@ -0,0 +1,94 @@
+package bitcask
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ValueCache(t *testing.T) {
+	// room for four of the 10 byte records
+	const cost = 5 + 5 + cacheOverhead
+	b, err := Open(t.TempDir(), WithCacheSize(4*cost+cost/2))
+	assert.NoError(t, err)
+	defer b.Close()
+	get := func(key, want string) {
+		t.Helper()
+		rec, err := b.Get([]byte(key))
+		if assert.NoError(t, err, key) {
+			assert.Equal(t, want, string(rec.Value), key)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d", i))))
+	}
+	assert.Equal(t, CacheStats{}, b.CacheStats())
+
+	get("key_0", "val_0")
+	get("key_0", "val_0")
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Size: cost, Entries: 1}, b.CacheStats())
+	// what Get returns is a copy
+	rec, err := b.Get([]byte("key_0"))
+	assert.NoError(t, err)
+	rec.Value[0] = 'X'
+	get("key_0", "val_0")
+
+	// the least recently used records are evicted
+	for i := 1; i < 5; i++ {
+		get(fmt.Sprintf("key_%d", i), fmt.Sprintf("val_%d", i))
+	}
+	stats := b.CacheStats()
+	assert.Equal(t, 4, stats.Entries)
+	assert.Equal(t, uint64(4*cost), stats.Size)
+	misses := stats.Misses
+	get("key_0", "val_0")
+	assert.Equal(t, misses+1, b.CacheStats().Misses)
+	get("key_4", "val_4")
+	assert.Equal(t, misses+1, b.CacheStats().Misses)
+
+	// overwrites and deletes drop the old record
+	assert.NoError(t, b.Put([]byte("key_4"), []byte("new_4")))
+	assert.Equal(t, 3, b.CacheStats().Entries)
+	get("key_4", "new_4")
+	assert.NoError(t, b.Delete([]byte("key_4")))
+	assert.Equal(t, 3, b.CacheStats().Entries)
+	_, err = b.Get([]byte("key_4"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	wb := NewWriteBatch()
+	wb.Put([]byte("key_0"), []byte("new_0"))
+	assert.NoError(t, b.Write(wb))
+	assert.Equal(t, 2, b.CacheStats().Entries)
+	get("key_0", "new_0")
+
+	// scans read from the cache but do not fill it
+	stats = b.CacheStats()
+	assert.NoError(t, b.Fold(func(key, value []byte) error { return nil }))
+	assert.Equal(t, stats.Entries, b.CacheStats().Entries)
+	assert.Equal(t, stats.Hits+uint64(stats.Entries), b.CacheStats().Hits)
+}
+
+func Test_ValueCacheMerge(t *testing.T) {
+	const recSize = RecordSize + 5 + 7
+	b, err := Open(t.TempDir(), WithMaxFileSize(FileHeaderSize+3*recSize), WithCacheSize(1<<20))
+	assert.NoError(t, err)
+	defer b.Close()
+	for i := 0; i < 9; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	assert.NoError(t, b.Delete([]byte("key_0")))
+	for i := 1; i < 9; i++ {
+		_, err := b.Get([]byte(fmt.Sprintf("key_%d", i)))
+		assert.NoError(t, err)
+	}
+	// the merged files reuse the ids of the files they replace, key_1 moves
+	// to where key_0 was and key_2 to where key_1 was
+	assert.NoError(t, b.Merge())
+	assert.Equal(t, uint64(FileHeaderSize+RecordSize+5), b.memDB.Search(NewTmpEntry([]byte("key_1"))).ValuePos)
+	for i := 1; i < 9; i++ {
+		rec, err := b.Get([]byte(fmt.Sprintf("key_%d", i)))
+		if assert.NoError(t, err) {
+			assert.Equal(t, fmt.Sprintf("value_%d", i), string(rec.Value))
+		}
+	}
+}
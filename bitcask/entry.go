@@ -1,16 +1,21 @@
 package bitcask
 
-import "bytes"
+import (
+	"bytes"
+	"time"
+)
 
 type Entry struct {
 	FileID    uint32 // unit32 最大能表示5G数字， 所以uni32 已经够用
-	TimeStamp uint32 //unit32 时间戳，以秒计算，可以表示136年
-	ValueSize uint32
-	ValuePos  uint32 // value 在数据文件中的偏移位置, 每个文件不超过1GB， 所以使用uint32
+	TimeStamp int64  // 纳秒时间戳
+	ValueSize uint64
+	ValuePos  uint64 // value 在数据文件中的偏移位置
+	Flags     uint8  // record flags read back from the data file, e.g. FlagTombstone
+	Expiry    int64  // 过期时间，以秒计算，0 表示不过期
 	Key       []byte
 }
 
-func NewEntry(key []byte, fileId uint32, valueSize uint32, valuePos uint32, timeStamp uint32) *Entry {
+func NewEntry(key []byte, fileId uint32, valueSize uint64, valuePos uint64, timeStamp int64) *Entry {
 	return &Entry{
 		Key:       key,
 		TimeStamp: timeStamp,
@@ -88,6 +93,15 @@ func (e *Entry) GreaterEq(other *Entry) bool {
 
 }
 
+func (e *Entry) IsTombstone() bool {
+	return e.Flags&FlagTombstone != 0
+}
+
+// Expired reports whether the entry has an expiry time at or before now
+func (e *Entry) Expired(now time.Time) bool {
+	return expired(e.Expiry, now)
+}
+
 type Entries []*Entry
 
 func (e Entries) Len() int { return len(e) }
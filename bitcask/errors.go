@@ -0,0 +1,60 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned by Get for a key that does not exist, was
+	// deleted or has expired
+	ErrNotFound = errors.New("bitcask: key not found")
+	// ErrChecksum is returned when a record does not match its checksum, it
+	// is wrapped in a FileError telling where the record is
+	ErrChecksum = errors.New("bitcask: checksum mismatch")
+	// ErrClosed is returned by every method of a closed store
+	ErrClosed = errors.New("bitcask: store is closed")
+	// ErrKeyTooLarge is returned for a key over Options.MaxKeySize, by
+	// writes and by reads of records written with a higher limit
+	ErrKeyTooLarge = errors.New("bitcask: key too large")
+	// ErrValueTooLarge is returned for a value over Options.MaxValueSize,
+	// like ErrKeyTooLarge, and by writes of a record or a batch that does
+	// not fit in a data file
+	ErrValueTooLarge = errors.New("bitcask: value too large")
+	// ErrUnsupportedFormat is returned for a data file written in a format
+	// version this package does not know, or appended to in an older one
+	ErrUnsupportedFormat = errors.New("bitcask: unsupported data file format")
+	// ErrReadOnly is returned by writes to a store opened read-only
+	ErrReadOnly = errors.New("bitcask: store is read-only")
+	// ErrLocked is returned by Open when another process or Bitcask holds
+	// the data directory
+	ErrLocked = errors.New("bitcask: data directory is locked")
+	// ErrMergePending is returned by a read-only Open when a completed merge
+	// still has to be moved into place, which needs a read-write Open
+	ErrMergePending = errors.New("bitcask: merge pending, open read-write to finish it")
+	// ErrMigratePending is returned by a read-only Open when Migrate was
+	// interrupted after moving the old files away, a read-write Open or
+	// Migrate moves the new ones into place
+	ErrMigratePending = errors.New("bitcask: migrate pending, open read-write to finish it")
+)
+
+// errBrokenFile is returned by appends to a data file a failed write left a
+// partial record in, see File.WriteRecords
+var errBrokenFile = errors.New("bitcask: a failed write left a partial record")
+
+// FileError is an error reading a data file, it tells which file and at
+// which offset. Use errors.Is to check for the error it wraps, such as
+// ErrChecksum or io.EOF.
+type FileError struct {
+	FileID uint32
+	Offset int64
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("data file %d at offset %d: %v", e.FileID, e.Offset, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
@@ -1,8 +1,11 @@
 package bitcask
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"hash/crc32"
+	"io"
 	"os"
 	"time"
 )
@@ -10,22 +13,44 @@ import (
 type File struct {
 	FileID     uint32
 	Path       string
-	CurrentPos uint32
-	FileSize   uint32
+	CurrentPos uint64
+	FileSize   uint64
 	Fd         *os.File
-	//FileLock   *FileLock
+	Mode       os.FileMode // permissions used when the file is created
+	ReadOnly   bool        // open O_RDONLY and never truncate
+	Version    uint16      // format version, set by OpenFile
+
+	// records read with a larger key or value are rejected before anything
+	// is allocated for them
+	MaxKeySize   uint32
+	MaxValueSize uint64
+
+	mmap   *mapping // set by Map
+	broken bool     // a failed write could not be undone, see WriteRecords
 }
 
 func NewFile(fileID uint32, Path string) *File {
 	return &File{
-		FileID:     fileID,
-		Path:       Path,
-		CurrentPos: 0,
+		FileID:       fileID,
+		Path:         Path,
+		CurrentPos:   0,
+		Mode:         0644,
+		MaxKeySize:   MaxKeySize,
+		MaxValueSize: MaxValueSize,
 	}
 }
 
+// Name returns the path of the data file
+func (f *File) Name() string {
+	return fmt.Sprintf("%s%d.data", f.Path, f.FileID)
+}
+
 func (f *File) OpenFile() error {
-	fd, err := os.OpenFile(fmt.Sprintf("%s%d.data", f.Path, f.FileID), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	flag := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if f.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	fd, err := os.OpenFile(f.Name(), flag, f.Mode)
 	if err != nil {
 		return err
 	}
@@ -34,12 +59,82 @@ func (f *File) OpenFile() error {
 	if err != nil {
 		return err
 	}
-	f.CurrentPos = uint32(0)
-	f.FileSize = uint32(size)
+	if err := f.initHeader(size); err != nil {
+		return err
+	}
+	if size, err = f.Size(); err != nil {
+		return err
+	}
+	// writes go to the end of the file with O_APPEND, positions must agree
+	f.CurrentPos = uint64(size)
+	f.FileSize = uint64(size)
+	return nil
+}
+
+// initHeader sets Version from the file header. A file without a header is
+// legacy, unless it is too short to hold a record in which case it is new,
+// or was torn while its header was written, and gets a current one.
+func (f *File) initHeader(size int64) error {
+	if size >= FileHeaderSize {
+		buf, err := f.Read(0, FileHeaderSize)
+		if err != nil {
+			return err
+		}
+		version, ok := decodeFileHeader(dataMagic, buf)
+		if !ok {
+			f.Version = FormatLegacy
+			return nil
+		}
+		if version <= FormatLegacy || version > FormatVersion {
+			return &FileError{FileID: f.FileID, Offset: 0, Err: fmt.Errorf("%w: version %d", ErrUnsupportedFormat, version)}
+		}
+		f.Version = version
+		return nil
+	}
+	f.Version = FormatVersion
+	if f.ReadOnly {
+		return nil
+	}
+	if size > 0 {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+	}
+	_, err := f.Write(encodeFileHeader(dataMagic, FormatVersion))
+	return err
+}
+
+// dataStart returns the offset of the first record
+func (f *File) dataStart() uint64 {
+	if f.Version == FormatLegacy {
+		return 0
+	}
+	return FileHeaderSize
+}
+
+// Resume makes the file ready for appends once ReadEntries has read it: a
+// torn record left at the end by a crash is cut off, so the next record is
+// written right after the last valid one and CurrentPos is its true offset.
+func (f *File) Resume() error {
+	size, err := f.Size()
+	if err != nil {
+		return err
+	}
+	if size > int64(f.CurrentPos) {
+		if err := f.Truncate(int64(f.CurrentPos)); err != nil {
+			return err
+		}
+	}
+	f.FileSize = f.CurrentPos
 	return nil
 }
 
 func (f *File) CloseFile() error {
+	if f.mmap != nil {
+		// views still in use keep the mapping until they are released
+		f.mmap.close()
+		f.mmap = nil
+	}
 	err := f.Fd.Close()
 	f.Fd = nil
 	return err
@@ -48,53 +143,284 @@ func (f *File) CloseFile() error {
 func (f *File) Write(data []byte) (int, error) {
 	return f.Fd.Write(data)
 }
-func (f *File) Read(offset uint32, size uint32) ([]byte, error) {
+
+// Read reads size bytes at offset, errors are wrapped in a FileError
+func (f *File) Read(offset uint64, size uint64) ([]byte, error) {
+	if m := f.mmap; m != nil {
+		if offset > uint64(len(m.data)) || size > uint64(len(m.data))-offset {
+			return nil, &FileError{FileID: f.FileID, Offset: int64(offset), Err: io.EOF}
+		}
+		buf := make([]byte, size)
+		copy(buf, m.data[offset:])
+		return buf, nil
+	}
 	buf := make([]byte, size)
 	_, err := f.Fd.ReadAt(buf, int64(offset))
 	if err != nil {
-		return nil, err
+		return nil, &FileError{FileID: f.FileID, Offset: int64(offset), Err: err}
 	}
 	return buf, nil
 }
 
+// ReadRecord reads the whole record that entry points at and checks it: the
+// header must match the entry, the key must be the entry key and the CRC must
+// match. A mismatch returns ErrChecksum wrapped in a FileError.
+func (f *File) ReadRecord(entry *Entry) (*Record, error) {
+	offset, size, err := f.recordSpan(entry)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := f.Read(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	return f.decodeAt(entry, offset, buf)
+}
+
+// recordSpan returns the offset and size of the record entry points at
+func (f *File) recordSpan(entry *Entry) (offset, size uint64, err error) {
+	hs := headerSize(f.Version, entry.Flags)
+	keySize := uint64(len(entry.Key))
+	if entry.ValuePos < f.dataStart()+hs+keySize {
+		return 0, 0, &FileError{FileID: f.FileID, Offset: int64(entry.ValuePos), Err: ErrChecksum}
+	}
+	return entry.ValuePos - hs - keySize, hs + keySize + entry.ValueSize, nil
+}
+
+// decodeAt checks the record of entry read from offset into buf, see
+// ReadRecord. The key and value of the record are slices of buf.
+func (f *File) decodeAt(entry *Entry, offset uint64, buf []byte) (*Record, error) {
+	if _, err := f.checkAt(entry, offset, buf); err != nil {
+		return nil, err
+	}
+	rec, err := viewRecord(f.Version, buf)
+	if err != nil {
+		return nil, &FileError{FileID: f.FileID, Offset: int64(offset), Err: err}
+	}
+	rec.ValuePos = entry.ValuePos
+	return rec, nil
+}
+
+// checkAt checks that the header and key of the record of entry read from
+// offset into buf describe that record, without the CRC, and returns it. The
+// key and value of the record are slices of buf and it has no Crc.
+func (f *File) checkAt(entry *Entry, offset uint64, buf []byte) (*Record, error) {
+	hs := headerSize(f.Version, entry.Flags)
+	keySize := uint64(len(entry.Key))
+	// the header has to describe the record the entry points at
+	header := decodeHeader(f.Version, buf)
+	if uint64(header.KeySize) != keySize || header.ValueSize != entry.ValueSize || headerSize(f.Version, header.Flags) != hs ||
+		!bytes.Equal(buf[hs:hs+keySize], entry.Key) {
+		return nil, &FileError{FileID: f.FileID, Offset: int64(offset), Err: ErrChecksum}
+	}
+	rec := &Record{
+		TimeStamp: entry.TimeStamp,
+		KeySize:   header.KeySize,
+		ValueSize: header.ValueSize,
+		ValuePos:  entry.ValuePos,
+		Flags:     entry.Flags,
+		Expiry:    entry.Expiry,
+		Key:       buf[hs : hs+keySize : hs+keySize],
+		Value:     buf[hs+keySize:],
+	}
+	return rec, nil
+}
+
 func (f *File) Seek(offset int64, whence int) (int64, error) {
 	return f.Fd.Seek(offset, whence)
 }
 func (f *File) ReadAt(buf []byte, offset int64) (int, error) {
 	return f.Fd.ReadAt(buf, offset)
 }
+
+// ReadEntry reads the record at CurrentPos and moves past it, on error
+// CurrentPos is left at the start of the record
 func (f *File) ReadEntry() (*Entry, error) {
 	oldPos := f.CurrentPos
-	buf, err := f.Read(f.CurrentPos, 20)
+	entry, err := f.readEntry()
 	if err != nil {
+		f.CurrentPos = oldPos
+	}
+	return entry, err
+}
+
+func (f *File) readEntry() (*Entry, error) {
+	oldPos := f.CurrentPos
+	rs := recordSize(f.Version)
+	buf, err := f.Read(f.CurrentPos, rs)
+	if err != nil {
+		return nil, err
+	}
+	header := decodeHeader(f.Version, buf)
+	hs := headerSize(f.Version, header.Flags)
+	if hs > rs {
+		ext, err := f.Read(f.CurrentPos+rs, hs-rs)
+		if err != nil {
+			return nil, err
+		}
+		header.Expiry = decodeExpiry(f.Version, ext)
+		buf = append(buf, ext...)
+	}
+	if err := f.checkHeader(oldPos, buf, header); err != nil {
 		return nil, err
 	}
-	f.CurrentPos += 20
-	header := DecodeHeader(buf)
-	key, err := f.Read(f.CurrentPos, header.KeySize)
+	f.CurrentPos += hs
+	key, err := f.Read(f.CurrentPos, uint64(header.KeySize))
 	if err != nil {
 		return nil, err
 	}
-	// Entry set
-	entry := NewEntry(key, f.FileID, header.ValueSize, header.ValuePos, header.TimeStamp)
-	f.CurrentPos += header.KeySize
+	f.CurrentPos += uint64(header.KeySize)
+	// Entry set, the value position comes from where the record was found
+	// rather than from the header, which older versions could get wrong
+	// when appending to a reopened file
+	entry := NewEntry(key, f.FileID, header.ValueSize, f.CurrentPos, header.TimeStamp)
+	entry.Flags = header.Flags
+	entry.Expiry = header.Expiry
 	value, err := f.Read(f.CurrentPos, header.ValueSize)
-	h := crc32.NewIEEE()
-	h.Write(key)
-	h.Write(value)
-	if header.Crc != h.Sum32() {
-		// Truncate file from oldPos to filePos
-		f.Truncate(int64(oldPos))
-		return nil, fmt.Errorf("checksum error")
-	}
 	if err != nil {
 		return nil, err
 	}
 	// checksum
-
+	if header.Crc != checksum(f.Version, header.Flags, header.Expiry, key, value) {
+		return nil, &FileError{FileID: f.FileID, Offset: int64(oldPos), Err: ErrChecksum}
+	}
+	// legacy records without flags deleted their key with an empty value
+	if f.Version == FormatLegacy && header.Flags == 0 && header.ValueSize == 0 {
+		entry.Flags = FlagTombstone
+	}
 	f.CurrentPos += header.ValueSize
 	return entry, nil
 }
+
+// checkHeader checks the header of the record at offset before its key and
+// value are read: the header checksum for formats that have one, then the
+// sizes against the end of the file and the size limits.
+func (f *File) checkHeader(offset uint64, buf []byte, header *RecordHeader) error {
+	if f.Version >= FormatV3 && header.HeaderCrc != headerChecksum(buf) {
+		return &FileError{FileID: f.FileID, Offset: int64(offset), Err: ErrChecksum}
+	}
+	// a torn record, or sizes from a corrupt header without a checksum
+	var left uint64
+	if end := offset + uint64(len(buf)); end < f.FileSize {
+		left = f.FileSize - end
+	}
+	if uint64(header.KeySize) > left || header.ValueSize > left-uint64(header.KeySize) {
+		return &FileError{FileID: f.FileID, Offset: int64(offset), Err: io.ErrUnexpectedEOF}
+	}
+	maxKeySize := f.MaxKeySize
+	if header.Flags&FlagBatchCommit != 0 {
+		// the key of a commit record is the batch length
+		maxKeySize = 4
+	}
+	if header.KeySize > maxKeySize {
+		return &FileError{FileID: f.FileID, Offset: int64(offset),
+			Err: fmt.Errorf("%w: %d bytes, the limit is %d", ErrKeyTooLarge, header.KeySize, maxKeySize)}
+	}
+	if header.ValueSize > f.MaxValueSize {
+		return &FileError{FileID: f.FileID, Offset: int64(offset),
+			Err: fmt.Errorf("%w: %d bytes, the limit is %d", ErrValueTooLarge, header.ValueSize, f.MaxValueSize)}
+	}
+	return nil
+}
+
+// ReadEntries reads all the entries of the file from the beginning. Records
+// of a batch are only returned once its commit record is read, the commit
+// records themselves are not returned.
+//
+// It stops without an error at a torn record at the end of the file, see
+// tornAt, leaving CurrentPos at its start. Any other record that cannot be
+// read is returned as an error with the entries before it: a corrupt record
+// in the middle of the file, or one over the size limits, is not the end of
+// the data and the records after it would be lost.
+func (f *File) ReadEntries() ([]*Entry, error) {
+	var entries []*Entry
+	var batch []*Entry
+	f.CurrentPos = f.dataStart()
+	for f.CurrentPos < f.FileSize {
+		entry, err := f.ReadEntry()
+		if errors.Is(err, ErrKeyTooLarge) || errors.Is(err, ErrValueTooLarge) {
+			return entries, err
+		}
+		if err != nil {
+			if f.tornAt(f.CurrentPos) {
+				break
+			}
+			return entries, err
+		}
+		switch {
+		case entry.Flags&FlagBatchCommit != 0:
+			// batches are written in one piece, anything before the last
+			// count records is left from a batch that was never committed
+			if len(entry.Key) != 4 {
+				batch = batch[:0]
+				continue
+			}
+			count := int(binary.BigEndian.Uint32(entry.Key))
+			if count <= len(batch) {
+				entries = append(entries, batch[len(batch)-count:]...)
+			}
+			batch = batch[:0]
+		case entry.Flags&FlagBatch != 0:
+			batch = append(batch, entry)
+		default:
+			batch = batch[:0]
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// tornAt reports whether the record at offset, which could not be read, is
+// the torn end of the file left by an interrupted write: it is not all there,
+// or it ends at the end of the file. A header without a checksum is trusted
+// for the size, so corrupt legacy and version 2 sizes look torn. A version 3
+// header that does not match its checksum is only torn if the rest of the
+// file is zeros, as left by a write whose data never reached the disk.
+func (f *File) tornAt(offset uint64) bool {
+	rs := recordSize(f.Version)
+	if offset+rs > f.FileSize {
+		return true
+	}
+	buf, err := f.Read(offset, rs)
+	if err != nil {
+		return true
+	}
+	header := decodeHeader(f.Version, buf)
+	hs := headerSize(f.Version, header.Flags)
+	if f.Version >= FormatV3 {
+		ext, err := f.Read(offset+rs, hs-rs)
+		if err != nil {
+			return true
+		}
+		if header.HeaderCrc != headerChecksum(append(buf, ext...)) {
+			return f.zeroFrom(offset)
+		}
+	}
+	end := offset + hs + uint64(header.KeySize)
+	return end > f.FileSize || f.FileSize-end <= header.ValueSize
+}
+
+// zeroFrom reports whether the file holds only zeros from offset to its end
+func (f *File) zeroFrom(offset uint64) bool {
+	buf := make([]byte, 64*1024)
+	for offset < f.FileSize {
+		n := uint64(len(buf))
+		if f.FileSize-offset < n {
+			n = f.FileSize - offset
+		}
+		if _, err := f.ReadAt(buf[:n], int64(offset)); err != nil {
+			return false
+		}
+		for _, c := range buf[:n] {
+			if c != 0 {
+				return false
+			}
+		}
+		offset += n
+	}
+	return true
+}
+
 func (f *File) Sync() error {
 	return f.Fd.Sync()
 }
@@ -112,17 +438,58 @@ func (f *File) Size() (int64, error) {
 	return stat.Size(), nil
 }
 func (f *File) Delete() error {
-	return os.Remove(fmt.Sprintf("%s%d.data", f.Path, f.FileID))
+	return os.Remove(f.Name())
 }
 func (f *File) Rename(newPath string) error {
-	return os.Rename(fmt.Sprintf("%s%d.data", f.Path, f.FileID), newPath)
+	return os.Rename(f.Name(), newPath)
 }
 func (f *File) WriteRecord(key, value []byte) (*Record, error) {
-	rec := NewRecord(uint32(time.Now().Unix()), key, f.CurrentPos+20+uint32(len(key)), value)
-	nums, err := f.Write(rec.Encode())
-	if err != nil {
+	return f.AppendRecord(NewRecord(time.Now().UnixNano(), key, 0, value))
+}
+
+// WriteTombstone appends a record marking key as deleted.
+func (f *File) WriteTombstone(key []byte) (*Record, error) {
+	return f.AppendRecord(NewTombstone(time.Now().UnixNano(), key, 0))
+}
+
+// AppendRecord appends rec and sets its ValuePos
+func (f *File) AppendRecord(rec *Record) (*Record, error) {
+	if err := f.WriteRecords([]*Record{rec}); err != nil {
 		return nil, err
 	}
-	f.CurrentPos += uint32(nums)
 	return rec, nil
 }
+
+// WriteRecords appends recs with a single write, their ValuePos is set from
+// the current position. Only files in the current format can be appended to.
+//
+// The file is opened O_APPEND, so what a failed write got out is cut off again
+// for the next record to start at CurrentPos. If that fails too the file is
+// broken and refuses further appends.
+func (f *File) WriteRecords(recs []*Record) error {
+	if f.Version != FormatVersion {
+		return &FileError{FileID: f.FileID, Offset: int64(f.CurrentPos), Err: fmt.Errorf("%w: cannot append to version %d", ErrUnsupportedFormat, f.Version)}
+	}
+	if f.broken {
+		return &FileError{FileID: f.FileID, Offset: int64(f.CurrentPos), Err: errBrokenFile}
+	}
+	var buf []byte
+	pos := f.CurrentPos
+	for _, rec := range recs {
+		rec.ValuePos = pos + rec.HeaderSize() + uint64(rec.KeySize)
+		pos = rec.ValuePos + rec.ValueSize
+		buf = append(buf, rec.Encode()...)
+	}
+	nums, err := f.Write(buf)
+	if err != nil {
+		if nums > 0 {
+			if err := f.Truncate(int64(f.CurrentPos)); err != nil {
+				f.broken = true
+			}
+		}
+		return err
+	}
+	f.CurrentPos += uint64(nums)
+	f.FileSize = f.CurrentPos
+	return nil
+}
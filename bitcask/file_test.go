@@ -2,11 +2,14 @@ package bitcask
 
 import (
 	"fmt"
+	"os"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestFile(t *testing.T) {
-	f := NewFile(1, "/tmp/")
+	f := NewFile(1, t.TempDir()+"/")
 	err := f.OpenFile()
 	if err != nil {
 		t.Error(err)
@@ -20,7 +23,7 @@ func TestFile(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	f := NewFile(2, "/tmp/")
+	f := NewFile(2, t.TempDir()+"/")
 	err := f.OpenFile()
 	if err != nil {
 		t.Error(err)
@@ -36,8 +39,8 @@ func TestUpdate(t *testing.T) {
 		t.Error(err)
 	}
 	println(rec)
-	// delete record, write empty value
-	rec, err = f.WriteRecord([]byte("key2"), []byte(""))
+	// delete record, write a tombstone
+	rec, err = f.WriteTombstone([]byte("key2"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -46,15 +49,109 @@ func TestUpdate(t *testing.T) {
 }
 
 func TestRead(t *testing.T) {
-	f := NewFile(1, "/tmp/")
+	f := NewFile(1, t.TempDir()+"/")
 	err := f.OpenFile()
 	if err != nil {
 		t.Error(err)
 	}
+	rec, err := f.WriteRecord([]byte("key2"), []byte("value2"))
+	if err != nil {
+		t.Error(err)
+	}
 	var buf []byte
-	buf, err = f.Read(114, 6)
+	buf, err = f.Read(rec.ValuePos, 6)
 	if err != nil {
 		t.Error(err)
 	}
 	fmt.Println(string(buf))
+	f.CloseFile()
+}
+
+func TestReadEntryTombstone(t *testing.T) {
+	path := t.TempDir() + "/"
+	f := NewFile(1, path)
+	assert.NoError(t, f.OpenFile())
+	_, err := f.WriteRecord([]byte("key"), []byte{})
+	assert.NoError(t, err)
+	_, err = f.WriteTombstone([]byte("key"))
+	assert.NoError(t, err)
+	f.CloseFile()
+
+	f = NewFile(1, path)
+	assert.NoError(t, f.OpenFile())
+	// OpenFile positions at the end for appends, read from the first record
+	f.CurrentPos = f.dataStart()
+	entry, err := f.ReadEntry()
+	assert.NoError(t, err)
+	assert.False(t, entry.IsTombstone())
+	entry, err = f.ReadEntry()
+	assert.NoError(t, err)
+	assert.True(t, entry.IsTombstone())
+	assert.Equal(t, "key", string(entry.Key))
+	f.CloseFile()
+}
+
+func TestReadEntryChecksum(t *testing.T) {
+	path := t.TempDir() + "/"
+	f := NewFile(1, path)
+	assert.NoError(t, f.OpenFile())
+	_, err := f.WriteRecord([]byte("key1"), []byte("value1"))
+	assert.NoError(t, err)
+	rec, err := f.WriteRecord([]byte("key2"), []byte("value2"))
+	assert.NoError(t, err)
+	f.CloseFile()
+	// flip a byte of the second value
+	fd, err := os.OpenFile(f.Name(), os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = fd.WriteAt([]byte("X"), int64(rec.ValuePos))
+	assert.NoError(t, err)
+	fd.Close()
+
+	f = NewFile(1, path)
+	f.ReadOnly = true
+	assert.NoError(t, f.OpenFile())
+	// OpenFile positions at the end for appends, read from the first record
+	f.CurrentPos = f.dataStart()
+	defer f.CloseFile()
+	_, err = f.ReadEntry()
+	assert.NoError(t, err)
+	_, err = f.ReadEntry()
+	assert.ErrorIs(t, err, ErrChecksum)
+	var fileErr *FileError
+	if assert.ErrorAs(t, err, &fileErr) {
+		assert.Equal(t, uint32(1), fileErr.FileID)
+		assert.Equal(t, int64(FileHeaderSize+RecordSize+4+6), fileErr.Offset)
+	}
+
+	data := NewRecord(1, []byte("key"), 0, []byte("value")).Encode()
+	data[len(data)-1] ^= 0xff
+	_, err = Decode(data)
+	assert.ErrorIs(t, err, ErrChecksum)
+}
+
+func TestReopenAppend(t *testing.T) {
+	path := t.TempDir() + "/"
+	f := NewFile(1, path)
+	assert.NoError(t, f.OpenFile())
+	rec1, err := f.WriteRecord([]byte("key1"), []byte("value1"))
+	assert.NoError(t, err)
+	f.CloseFile()
+
+	f = NewFile(1, path)
+	assert.NoError(t, f.OpenFile())
+	defer f.CloseFile()
+	assert.Equal(t, rec1.ValuePos+rec1.ValueSize, f.CurrentPos)
+	rec2, err := f.WriteRecord([]byte("key2"), []byte("value2"))
+	assert.NoError(t, err)
+	assert.Equal(t, rec1.ValuePos+rec1.ValueSize+RecordSize+4, rec2.ValuePos)
+	value, err := f.Read(rec2.ValuePos, rec2.ValueSize)
+	assert.NoError(t, err)
+	assert.Equal(t, "value2", string(value))
+
+	entries, err := f.ReadEntries()
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, rec1.ValuePos, entries[0].ValuePos)
+		assert.Equal(t, rec2.ValuePos, entries[1].ValuePos)
+	}
 }
@@ -0,0 +1,39 @@
+package bitcask
+
+// Keys returns a copy of all the keys in order, nil once the store is closed
+func (b *Bitcask) Keys() [][]byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil
+	}
+	keys := make([][]byte, 0, b.memDB.Len())
+	t := now()
+	b.memDB.Walk(func(e *Entry) bool {
+		if !e.Expired(t) {
+			keys = append(keys, append([]byte(nil), e.Key...))
+		}
+		return true
+	})
+	return keys
+}
+
+// Len returns the number of keys, keys that expired since the store was
+// opened are still counted
+func (b *Bitcask) Len() int {
+	return b.memDB.Len()
+}
+
+// Fold calls fn for every key and its value in key order, stopping at the
+// first error which is returned. Like Scan it does not block writers, a key
+// written during the fold may or may not be seen.
+func (b *Bitcask) Fold(fn func(key, value []byte) error) error {
+	it := b.Scan(nil, nil)
+	defer it.Close()
+	for it.Next() {
+		if err := fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
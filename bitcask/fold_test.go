@@ -0,0 +1,54 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_KeysLenFold(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, b.Len())
+	for i := 9; i >= 0; i-- {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	assert.NoError(t, b.Put([]byte("key_1"), []byte("value_1")))
+	assert.NoError(t, b.Delete([]byte("key_5")))
+	assert.Equal(t, 9, b.Len())
+
+	keys := b.Keys()
+	assert.Len(t, keys, 9)
+	assert.Equal(t, "key_0", string(keys[0]))
+	assert.Equal(t, "key_9", string(keys[8]))
+
+	var folded []string
+	assert.NoError(t, b.Fold(func(key, value []byte) error {
+		assert.Equal(t, "value"+string(key[3:]), string(value))
+		folded = append(folded, string(key))
+		return nil
+	}))
+	assert.Len(t, folded, 9)
+	assert.NotContains(t, folded, "key_5")
+
+	stop := errors.New("stop")
+	n := 0
+	assert.ErrorIs(t, b.Fold(func(key, value []byte) error {
+		n++
+		if n == 3 {
+			return stop
+		}
+		return nil
+	}), stop)
+	assert.Equal(t, 3, n)
+	assert.NoError(t, b.Close())
+
+	// the count is rebuilt on open
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, b.Len())
+	assert.NoError(t, b.Close())
+}
@@ -0,0 +1,249 @@
+package bitcask
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+// Data files written by this package start with a file header giving the
+// format version:
+//
+//	| Magic "SBKD" | Version uint16 | reserved uint16 |
+//
+// followed by version 3 records:
+//
+//	| HeaderCrc uint32 | Crc uint32 | Flags uint8 | TimeStamp int64 | KeySize uint32 | ValueSize uint64 | [Expiry int64] | Key | Value |
+//
+// TimeStamp is in nanoseconds and Expiry in seconds since the epoch, Expiry
+// is only present with FlagExpiry. The position of the value is not stored,
+// it follows from where the record is. HeaderCrc covers the rest of the
+// header, Crc included, so the sizes can be trusted before the key and value
+// are read.
+//
+// Version 2 records are the same without HeaderCrc.
+//
+// Files written before version 2 have no file header and hold legacy records
+// with 32-bit fields, the flags in the high byte of KeySize and the time in
+// seconds:
+//
+//	| Crc uint32 | TimeStamp uint32 | KeySize + flags uint32 | ValueSize uint32 | ValuePos uint32 | [Expiry uint32] | Key | Value |
+//
+// A legacy record without flags and with an empty value is a deletion, as
+// it was before tombstones had a flag.
+//
+// In all formats Crc covers the flags when any is set, the expiry when
+// present, the key and the value. Files in older formats are read but never
+// written to, a store whose current file is older starts a new file on open.
+
+const (
+	// FormatLegacy is the format of data files without a file header
+	FormatLegacy uint16 = 1
+	FormatV2     uint16 = 2
+	FormatV3     uint16 = 3
+	// FormatVersion is the format of the data files written by this package
+	FormatVersion = FormatV3
+
+	// FileHeaderSize is the size of the header at the start of data and hint
+	// files, records follow it
+	FileHeaderSize = 8
+	// RecordSize is the size of the fixed record header, the optional
+	// expiry and then the key follow it
+	RecordSize = 29
+	// ExpirySize is the size of the optional expiry header field
+	ExpirySize = 8
+
+	v2RecordSize     = 25
+	legacyRecordSize = 20
+	legacyExpirySize = 4
+	// legacy records store their flags in the high byte of the KeySize field
+	flagShift          = 24
+	keySizeMask uint32 = 1<<flagShift - 1
+)
+
+var dataMagic = [4]byte{'S', 'B', 'K', 'D'}
+
+func encodeFileHeader(magic [4]byte, version uint16) []byte {
+	data := make([]byte, FileHeaderSize)
+	copy(data[0:4], magic[:])
+	binary.BigEndian.PutUint16(data[4:6], version)
+	return data
+}
+
+// decodeFileHeader returns the version from a file header, ok is false if
+// data does not start with magic
+func decodeFileHeader(magic [4]byte, data []byte) (version uint16, ok bool) {
+	if len(data) < FileHeaderSize || !bytes.Equal(data[0:4], magic[:]) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(data[4:6]), true
+}
+
+// recordSize returns the size of the fixed record header of a format version
+func recordSize(version uint16) uint64 {
+	switch version {
+	case FormatLegacy:
+		return legacyRecordSize
+	case FormatV2:
+		return v2RecordSize
+	}
+	return RecordSize
+}
+
+// headerSize returns the size of the header of a record with flags, the key
+// follows it
+func headerSize(version uint16, flags uint8) uint64 {
+	size := recordSize(version)
+	if flags&FlagExpiry != 0 {
+		if version == FormatLegacy {
+			return size + legacyExpirySize
+		}
+		return size + ExpirySize
+	}
+	return size
+}
+
+// checksum covers the key and the value, and the flags and expiry when they
+// are set so that records written before they existed keep their checksum.
+func checksum(version uint16, flags uint8, expiry int64, key, value []byte) uint32 {
+	h := crc32.NewIEEE()
+	if flags != 0 {
+		h.Write([]byte{flags})
+	}
+	if flags&FlagExpiry != 0 {
+		if version == FormatLegacy {
+			h.Write(binary.BigEndian.AppendUint32(nil, uint32(expiry)))
+		} else {
+			h.Write(binary.BigEndian.AppendUint64(nil, uint64(expiry)))
+		}
+	}
+	h.Write(key)
+	h.Write(value)
+	return h.Sum32()
+}
+
+// headerChecksum is the HeaderCrc of a version 3 record header, it covers
+// everything after the HeaderCrc field up to the key
+func headerChecksum(header []byte) uint32 {
+	return crc32.ChecksumIEEE(header[4:])
+}
+
+// encodeRecord encodes r in a format version, the checksum is recomputed
+// unless it is the current format
+func encodeRecord(version uint16, r *Record) []byte {
+	hs := headerSize(version, r.Flags)
+	data := make([]byte, hs+uint64(len(r.Key))+uint64(len(r.Value)))
+	crc := r.Crc
+	if version != FormatVersion {
+		crc = checksum(version, r.Flags, r.Expiry, r.Key, r.Value)
+	}
+	if version == FormatLegacy {
+		binary.BigEndian.PutUint32(data[0:4], crc)
+		binary.BigEndian.PutUint32(data[4:8], uint32(r.TimeStamp/int64(time.Second)))
+		binary.BigEndian.PutUint32(data[8:12], r.KeySize|uint32(r.Flags)<<flagShift)
+		binary.BigEndian.PutUint32(data[12:16], uint32(r.ValueSize))
+		binary.BigEndian.PutUint32(data[16:20], uint32(r.ValuePos))
+		if r.Flags&FlagExpiry != 0 {
+			binary.BigEndian.PutUint32(data[20:24], uint32(r.Expiry))
+		}
+	} else {
+		// version 3 has HeaderCrc in front of the version 2 header
+		h := data
+		if version >= FormatV3 {
+			h = data[4:]
+		}
+		binary.BigEndian.PutUint32(h[0:4], crc)
+		h[4] = r.Flags
+		binary.BigEndian.PutUint64(h[5:13], uint64(r.TimeStamp))
+		binary.BigEndian.PutUint32(h[13:17], r.KeySize)
+		binary.BigEndian.PutUint64(h[17:25], r.ValueSize)
+		if r.Flags&FlagExpiry != 0 {
+			binary.BigEndian.PutUint64(h[25:33], uint64(r.Expiry))
+		}
+		if version >= FormatV3 {
+			binary.BigEndian.PutUint32(data[0:4], headerChecksum(data[:hs]))
+		}
+	}
+	copy(data[hs:], r.Key)
+	copy(data[hs+uint64(r.KeySize):], r.Value)
+	return data
+}
+
+// decodeHeader decodes the fixed record header at the start of data, which
+// must hold recordSize(version) bytes. The expiry is read by decodeExpiry.
+func decodeHeader(version uint16, data []byte) *RecordHeader {
+	h := &RecordHeader{}
+	if version >= FormatV3 {
+		h.HeaderCrc = binary.BigEndian.Uint32(data[0:4])
+		data = data[4:]
+	}
+	h.Crc = binary.BigEndian.Uint32(data[0:4])
+	if version == FormatLegacy {
+		h.TimeStamp = int64(binary.BigEndian.Uint32(data[4:8])) * int64(time.Second)
+		h.KeySize = binary.BigEndian.Uint32(data[8:12]) & keySizeMask
+		h.Flags = uint8(binary.BigEndian.Uint32(data[8:12]) >> flagShift)
+		h.ValueSize = uint64(binary.BigEndian.Uint32(data[12:16]))
+		h.ValuePos = uint64(binary.BigEndian.Uint32(data[16:20]))
+	} else {
+		h.Flags = data[4]
+		h.TimeStamp = int64(binary.BigEndian.Uint64(data[5:13]))
+		h.KeySize = binary.BigEndian.Uint32(data[13:17])
+		h.ValueSize = binary.BigEndian.Uint64(data[17:25])
+	}
+	return h
+}
+
+// decodeExpiry decodes the expiry field that follows the fixed header
+func decodeExpiry(version uint16, data []byte) int64 {
+	if version == FormatLegacy {
+		return int64(binary.BigEndian.Uint32(data))
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+// decodeRecord decodes a whole record in a format version and checks it
+func decodeRecord(version uint16, data []byte) (*Record, error) {
+	record, err := viewRecord(version, data)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, record.KeySize)
+	value := make([]byte, record.ValueSize)
+	copy(key, record.Key)
+	copy(value, record.Value)
+	record.Key = key
+	record.Value = value
+	return record, nil
+}
+
+// viewRecord is decodeRecord with the key and value of the record left in
+// data rather than copied
+func viewRecord(version uint16, data []byte) (*Record, error) {
+	if uint64(len(data)) < recordSize(version) {
+		return nil, ErrChecksum
+	}
+	header := decodeHeader(version, data)
+	hs := headerSize(version, header.Flags)
+	if uint64(len(data)) < hs || uint64(len(data))-hs < uint64(header.KeySize) ||
+		uint64(len(data))-hs-uint64(header.KeySize) < header.ValueSize {
+		return nil, ErrChecksum
+	}
+	if header.Flags&FlagExpiry != 0 {
+		header.Expiry = decodeExpiry(version, data[recordSize(version):])
+	}
+	if version >= FormatV3 && header.HeaderCrc != headerChecksum(data[:hs]) {
+		return nil, ErrChecksum
+	}
+	ks := hs + uint64(header.KeySize)
+	key := data[hs:ks:ks]
+	value := data[ks : ks+header.ValueSize : ks+header.ValueSize]
+	if header.Crc != checksum(version, header.Flags, header.Expiry, key, value) {
+		return nil, ErrChecksum
+	}
+	record := newRecord(header.TimeStamp, key, header.ValuePos, value, header.Flags, header.Expiry)
+	// keep the checksum as stored, it differs from the current format's
+	// for legacy records with an expiry
+	record.Crc = header.Crc
+	return record, nil
+}
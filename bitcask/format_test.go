@@ -0,0 +1,252 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RecordFormats(t *testing.T) {
+	ts := time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC).UnixNano()
+	recs := []*Record{
+		NewRecord(ts, []byte("key"), 0, []byte("value")),
+		NewTombstone(ts, []byte("key"), 0),
+		NewExpiringRecord(ts, []byte("key"), 0, []byte("value"), 1<<40),
+	}
+	for _, version := range []uint16{FormatV2, FormatV3} {
+		for _, rec := range recs {
+			data := encodeRecord(version, rec)
+			assert.Equal(t, int(headerSize(version, rec.Flags))+3+len(rec.Value), len(data))
+			got, err := decodeRecord(version, data)
+			if assert.NoError(t, err) {
+				assert.Equal(t, data, encodeRecord(version, got))
+				assert.Equal(t, rec.Crc, got.Crc)
+			}
+		}
+	}
+
+	// legacy records keep whole seconds and 32-bit fields
+	legacy := NewExpiringRecord(ts, []byte("key"), 123, []byte("value"), 1<<31)
+	data := encodeRecord(FormatLegacy, legacy)
+	assert.Len(t, data, legacyRecordSize+legacyExpirySize+3+5)
+	got, err := decodeRecord(FormatLegacy, data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ts/int64(time.Second)*int64(time.Second), got.TimeStamp)
+		assert.Equal(t, int64(1<<31), got.Expiry)
+		assert.Equal(t, uint64(123), got.ValuePos)
+		assert.Equal(t, "value", string(got.Value))
+	}
+	// a record does not decode in the other format
+	_, err = decodeRecord(FormatV2, data)
+	assert.ErrorIs(t, err, ErrChecksum)
+}
+
+// writeLegacyFile writes recs to data file fileID in the legacy format
+func writeLegacyFile(t *testing.T, dir string, fileID uint32, recs ...*Record) {
+	var data []byte
+	for _, rec := range recs {
+		rec.ValuePos = uint64(len(data)) + headerSize(FormatLegacy, rec.Flags) + uint64(rec.KeySize)
+		data = append(data, encodeRecord(FormatLegacy, rec)...)
+	}
+	assert.NoError(t, os.WriteFile(fmt.Sprintf("%s/%d.data", dir, fileID), data, 0644))
+}
+
+func Test_LegacyDataFiles(t *testing.T) {
+	dir := t.TempDir()
+	ts := time.Now().UnixNano()
+	commit := []byte{0, 0, 0, 2}
+	writeLegacyFile(t, dir, 1,
+		NewRecord(ts, []byte("a"), 0, []byte("value_a")),
+		NewRecord(ts, []byte("b"), 0, []byte("value_b")),
+		NewTombstone(ts, []byte("a"), 0),
+		NewExpiringRecord(ts, []byte("c"), 0, []byte("value_c"), now().Add(time.Hour).Unix()),
+		NewRecordWithFlags(ts, []byte("d"), 0, []byte("value_d"), FlagBatch),
+		NewRecordWithFlags(ts, []byte("e"), 0, []byte("value_e"), FlagBatch),
+		NewRecordWithFlags(ts, commit, 0, nil, FlagBatchCommit),
+	)
+	writeLegacyFile(t, dir, 2,
+		NewRecord(ts, []byte("b"), 0, []byte("new_value_b")),
+		NewRecord(ts, []byte("f"), 0, []byte("value_f")),
+		NewRecord(ts, []byte("h"), 0, []byte("value_h")),
+		// deletions were records with an empty value
+		NewRecord(ts, []byte("h"), 0, nil),
+	)
+	// a hint in the old headerless format is ignored
+	assert.NoError(t, os.WriteFile(dir+"/1.hint", make([]byte, 40), 0644))
+
+	want := map[string]string{
+		"b": "new_value_b",
+		"c": "value_c",
+		"d": "value_d",
+		"e": "value_e",
+		"f": "value_f",
+	}
+	check := func(b *Bitcask) {
+		for k, v := range want {
+			rec, err := b.Get([]byte(k))
+			if assert.NoError(t, err, k) {
+				assert.Equal(t, v, string(rec.Value), k)
+			}
+		}
+		for _, k := range []string{"a", "h"} {
+			_, err := b.Get([]byte(k))
+			assert.ErrorIs(t, err, ErrNotFound, k)
+		}
+	}
+
+	// read-only openers read the legacy files as they are
+	b, err := Open(dir, WithReadOnly(true))
+	assert.NoError(t, err)
+	check(b)
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	check(b)
+	// new writes go to a new file in the current format
+	assert.Equal(t, []uint32{1, 2, 3}, b.FileIDs())
+	assert.Equal(t, FormatVersion, b.CurrentFile.Version)
+	want["g"] = "value_g"
+	assert.NoError(t, b.Put([]byte("g"), []byte("value_g")))
+	check(b)
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	check(b)
+	entries, err := ReadHintFile(b.Path, 1)
+	assert.NoError(t, err)
+	// the tombstone is kept in the hint
+	assert.Len(t, entries, 6)
+
+	// merging rewrites the legacy files in the current format
+	assert.NoError(t, b.Merge())
+	check(b)
+	for _, id := range b.FileIDs() {
+		assert.Equal(t, FormatVersion, b.files.get(id).Version, "file %d", id)
+	}
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	check(b)
+}
+
+func Test_FileHeader(t *testing.T) {
+	dir := t.TempDir()
+	// a header from a newer version
+	assert.NoError(t, os.WriteFile(dir+"/1.data", encodeFileHeader(dataMagic, FormatVersion+1), 0644))
+	_, err := Open(dir)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	// a header torn by a crash is rewritten
+	assert.NoError(t, os.WriteFile(dir+"/1.data", dataMagic[:3], 0644))
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("key"), []byte("value")))
+	assert.NoError(t, b.Close())
+	data, err := os.ReadFile(dir + "/1.data")
+	assert.NoError(t, err)
+	version, ok := decodeFileHeader(dataMagic, data)
+	assert.True(t, ok)
+	assert.Equal(t, FormatVersion, version)
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	v, err := b.Get([]byte("key"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "value", string(v.Value))
+	}
+}
+
+// allocated returns the bytes allocated by fn
+func allocated(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func Test_CorruptHeader(t *testing.T) {
+	rec := NewRecord(1, []byte("key"), 0, []byte("value"))
+	data := rec.Encode()
+	// a bit flip in ValueSize is caught by the header checksum
+	data[RecordSize-1] ^= 0x80
+	_, err := Decode(data)
+	assert.ErrorIs(t, err, ErrChecksum)
+
+	for _, version := range []uint16{FormatLegacy, FormatV2, FormatV3} {
+		dir := t.TempDir()
+		var file []byte
+		if version != FormatLegacy {
+			file = encodeFileHeader(dataMagic, version)
+		}
+		data := encodeRecord(version, rec)
+		// set the top bits of ValueSize
+		if version == FormatLegacy {
+			data[12] = 0xff
+		} else {
+			data[recordSize(version)-8] = 0xff
+		}
+		file = append(file, data...)
+		assert.NoError(t, os.WriteFile(dir+"/1.data", file, 0644))
+
+		f := NewFile(1, dir+"/")
+		f.ReadOnly = true
+		assert.NoError(t, f.OpenFile())
+		assert.Equal(t, version, f.Version)
+		f.CurrentPos = f.dataStart()
+		n := allocated(func() {
+			_, err = f.ReadEntry()
+		})
+		assert.Error(t, err, "version %d", version)
+		var fileErr *FileError
+		assert.ErrorAs(t, err, &fileErr)
+		assert.Less(t, n, uint64(1<<20), "version %d", version)
+		f.CloseFile()
+	}
+}
+
+func Test_SizeLimits(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir, WithMaxKeySize(8), WithMaxValueSize(16))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, b.Put([]byte("123456789"), nil), ErrKeyTooLarge)
+	assert.ErrorIs(t, b.Put([]byte("key"), make([]byte, 17)), ErrValueTooLarge)
+	wb := NewWriteBatch()
+	wb.Put([]byte("key"), make([]byte, 17))
+	assert.ErrorIs(t, b.Write(wb), ErrValueTooLarge)
+	assert.NoError(t, b.Put([]byte("12345678"), make([]byte, 16)))
+	assert.NoError(t, b.Close())
+
+	_, err = Open(dir, WithMaxKeySize(0))
+	assert.Error(t, err)
+
+	// records over a lower limit are not dropped as if they were damage
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("big"), make([]byte, 100)))
+	assert.NoError(t, b.Put([]byte("after"), []byte("value")))
+	assert.NoError(t, b.Close())
+	size := dirState(t, dir)
+	_, err = Open(dir, WithMaxValueSize(16))
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	var fileErr *FileError
+	assert.ErrorAs(t, err, &fileErr)
+	assert.Equal(t, size, dirState(t, dir))
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	v, err := b.Get([]byte("after"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "value", string(v.Value))
+	}
+}
@@ -0,0 +1,128 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// HintRecordSize is the size of a hint record header, the key follows it
+const HintRecordSize = 33
+
+// A hint file N.hint sits next to a sealed or merged data file N.data. It
+// starts with a file header like the data files, with its own magic, and
+// holds one record per data record, without the value:
+//
+//	| TimeStamp int64 | FileID uint32 | Flags uint8 | KeySize uint32 | ValueSize uint64 | ValuePos uint64 | [Expiry int64] | Key |
+//
+// Expiry is only present with FlagExpiry, as in the data file. A hint file
+// in another format, like the headerless ones written before, is ignored
+// and rebuilt from the data file.
+//
+// Loading it rebuilds the keydir for that file without reading the values.
+
+var hintMagic = [4]byte{'S', 'B', 'K', 'H'}
+
+func hintName(path string, fileID uint32) string {
+	return fmt.Sprintf("%s%d.hint", path, fileID)
+}
+
+func hintSize(flags uint8) int {
+	if flags&FlagExpiry != 0 {
+		return HintRecordSize + ExpirySize
+	}
+	return HintRecordSize
+}
+
+func encodeHint(e *Entry) []byte {
+	hs := hintSize(e.Flags)
+	data := make([]byte, hs+len(e.Key))
+	binary.BigEndian.PutUint64(data[0:8], uint64(e.TimeStamp))
+	binary.BigEndian.PutUint32(data[8:12], e.FileID)
+	data[12] = e.Flags
+	binary.BigEndian.PutUint32(data[13:17], uint32(len(e.Key)))
+	binary.BigEndian.PutUint64(data[17:25], e.ValueSize)
+	binary.BigEndian.PutUint64(data[25:33], e.ValuePos)
+	if e.Flags&FlagExpiry != 0 {
+		binary.BigEndian.PutUint64(data[33:41], uint64(e.Expiry))
+	}
+	copy(data[hs:], e.Key)
+	return data
+}
+
+// WriteHintFile writes the hint file of data file fileID in path. The hint is
+// written to a temporary file first and renamed, so a hint file is either
+// complete or missing.
+func WriteHintFile(path string, fileID uint32, entries []*Entry, perm os.FileMode) error {
+	name := hintName(path, fileID)
+	fd, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	buf := encodeFileHeader(hintMagic, FormatVersion)
+	for _, e := range entries {
+		buf = append(buf, encodeHint(e)...)
+	}
+	if _, err := fd.Write(buf); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// ReadHintFile loads the entries of data file fileID from its hint file
+func ReadHintFile(path string, fileID uint32) ([]*Entry, error) {
+	data, err := os.ReadFile(hintName(path, fileID))
+	if err != nil {
+		return nil, err
+	}
+	if version, ok := decodeFileHeader(hintMagic, data); !ok || version != FormatVersion {
+		return nil, fmt.Errorf("hint file %d: unknown format", fileID)
+	}
+	var entries []*Entry
+	for pos := FileHeaderSize; pos < len(data); {
+		if len(data)-pos < HintRecordSize {
+			return nil, fmt.Errorf("hint file %d: truncated record at %d", fileID, pos)
+		}
+		header := data[pos : pos+HintRecordSize]
+		flags := header[12]
+		keySize := int(binary.BigEndian.Uint32(header[13:17]))
+		hs := hintSize(flags)
+		if len(data)-pos-hs < keySize {
+			return nil, fmt.Errorf("hint file %d: truncated key at %d", fileID, pos)
+		}
+		key := make([]byte, keySize)
+		copy(key, data[pos+hs:])
+		entry := NewEntry(key,
+			binary.BigEndian.Uint32(header[8:12]),
+			binary.BigEndian.Uint64(header[17:25]),
+			binary.BigEndian.Uint64(header[25:33]),
+			int64(binary.BigEndian.Uint64(header[0:8])))
+		entry.Flags = flags
+		if flags&FlagExpiry != 0 {
+			entry.Expiry = int64(binary.BigEndian.Uint64(data[pos+HintRecordSize:]))
+		}
+		if entry.FileID != fileID {
+			return nil, fmt.Errorf("hint file %d: record at %d belongs to file %d", fileID, pos, entry.FileID)
+		}
+		entries = append(entries, entry)
+		pos += hs + keySize
+	}
+	return entries, nil
+}
+
+// RemoveHintFile removes the hint file of data file fileID, if any
+func RemoveHintFile(path string, fileID uint32) error {
+	err := os.Remove(hintName(path, fileID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
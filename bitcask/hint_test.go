@@ -0,0 +1,148 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HintFile(t *testing.T) {
+	path := t.TempDir() + "/"
+	tomb := NewEntry([]byte("key2"), 3, 0, 40, 1001)
+	tomb.Flags = FlagTombstone
+	entries := []*Entry{
+		NewEntry([]byte("key1"), 3, 10, 20, 1000),
+		tomb,
+		NewEntry([]byte{}, 3, 0, 60, 1002),
+	}
+	assert.NoError(t, WriteHintFile(path, 3, entries, 0644))
+	loaded, err := ReadHintFile(path, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, len(entries), len(loaded))
+	for i := range entries {
+		assert.Equal(t, entries[i].Key, loaded[i].Key)
+		assert.Equal(t, entries[i].FileID, loaded[i].FileID)
+		assert.Equal(t, entries[i].ValueSize, loaded[i].ValueSize)
+		assert.Equal(t, entries[i].ValuePos, loaded[i].ValuePos)
+		assert.Equal(t, entries[i].TimeStamp, loaded[i].TimeStamp)
+		assert.Equal(t, entries[i].Flags, loaded[i].Flags)
+	}
+
+	// a truncated hint file is rejected
+	data, err := os.ReadFile(hintName(path, 3))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(hintName(path, 3), data[:len(data)-1], 0644))
+	_, err = ReadHintFile(path, 3)
+	assert.Error(t, err)
+}
+
+func Test_HintStartup(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBitcask(dir)
+	b.Open()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	assert.NoError(t, b.Delete([]byte("key_0")))
+	b.rotate(MaxFileSize)
+	assert.NoError(t, b.Put([]byte("key_1"), []byte("new_value")))
+	b.Close()
+
+	// the sealed file got a hint, the current file did not
+	_, err := os.Stat(hintName(b.Path, 1))
+	assert.NoError(t, err)
+	_, err = os.Stat(hintName(b.Path, 2))
+	assert.True(t, os.IsNotExist(err))
+
+	check := func() {
+		b := NewBitcask(dir)
+		b.Open()
+		defer b.Close()
+		for i := 0; i < 10; i++ {
+			v, err := b.Get([]byte(fmt.Sprintf("key_%d", i)))
+			if i == 0 {
+				assert.ErrorIs(t, err, ErrNotFound)
+				continue
+			}
+			assert.NoError(t, err)
+			switch i {
+			case 1:
+				assert.Equal(t, "new_value", string(v.Value))
+			default:
+				assert.Equal(t, fmt.Sprintf("value_%d", i), string(v.Value))
+			}
+		}
+	}
+	check()
+
+	// without the hint the data file is scanned and the hint rewritten
+	assert.NoError(t, RemoveHintFile(b.Path, 1))
+	check()
+	_, err = os.Stat(hintName(b.Path, 1))
+	assert.NoError(t, err)
+}
+
+func Test_HintOnRotate(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("a"), []byte("value_a")))
+	assert.NoError(t, b.PutWithTTL([]byte("b"), []byte("value_b"), time.Hour))
+	assert.NoError(t, b.Close())
+
+	// the hint is built from what was replayed and what is written after
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Delete([]byte("a")))
+	wb := NewWriteBatch()
+	wb.Put([]byte("c"), []byte("value_c"))
+	wb.Delete([]byte("b"))
+	assert.NoError(t, b.Write(wb))
+	assert.NoError(t, b.Put([]byte("d"), []byte("value_d")))
+	assert.NoError(t, b.rotate(MaxFileSize))
+	assert.NoError(t, b.Close())
+
+	hint, err := ReadHintFile(b.Path, 1)
+	assert.NoError(t, err)
+	f := NewFile(1, b.Path)
+	f.ReadOnly = true
+	assert.NoError(t, f.OpenFile())
+	defer f.CloseFile()
+	entries, err := f.ReadEntries()
+	assert.NoError(t, err)
+	assert.Len(t, hint, 6)
+	assert.True(t, sameEntries(hint, entries))
+}
+
+func Test_HintFailedRotate(t *testing.T) {
+	// three 41 byte records per file
+	const recSize = RecordSize + 5 + 7
+	dir := t.TempDir()
+	b, err := Open(dir, WithMaxFileSize(FileHeaderSize+3*recSize))
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	// the next file cannot be created, a smaller record still fits
+	assert.NoError(t, os.Mkdir(dir+"/2.data", 0755))
+	assert.Error(t, b.Put([]byte("large"), make([]byte, 20)))
+	assert.NoError(t, b.Put([]byte("key_2"), []byte("value_2")))
+	assert.NoError(t, os.Remove(dir+"/2.data"))
+	assert.NoError(t, b.Put([]byte("key_3"), []byte("value_3")))
+	assert.Equal(t, []uint32{1, 2}, b.FileIDs())
+	assert.NoError(t, b.Close())
+
+	// the hint of file 1 has all of its records
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	for i := 0; i < 4; i++ {
+		v, err := b.Get([]byte(fmt.Sprintf("key_%d", i)))
+		if assert.NoError(t, err, i) {
+			assert.Equal(t, fmt.Sprintf("value_%d", i), string(v.Value))
+		}
+	}
+}
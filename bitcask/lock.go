@@ -0,0 +1,51 @@
+package bitcask
+
+import "os"
+
+// lockFileName is the lock file in the data directory, a writer holds an
+// exclusive lock on it while the store is open and read-only openers share
+// the lock, see acquireLock.
+const lockFileName = "LOCK"
+
+type fileLock struct {
+	fd *os.File
+}
+
+func (l *fileLock) release() error {
+	if l == nil || l.fd == nil {
+		return nil
+	}
+	if err := unlockFile(l.fd); err != nil {
+		l.fd.Close()
+		return err
+	}
+	err := l.fd.Close()
+	l.fd = nil
+	return err
+}
+
+// acquireLock locks the data directory at path, exclusively unless shared is
+// set. It returns ErrLocked when another opener holds a conflicting lock.
+//
+// A shared lock never creates the lock file, if it is missing no writer has
+// opened the store and there is nothing to lock against.
+func acquireLock(path string, shared bool, perm os.FileMode) (*fileLock, error) {
+	var fd *os.File
+	var err error
+	if shared {
+		fd, err = os.OpenFile(path+lockFileName, os.O_RDONLY, 0)
+		if os.IsNotExist(err) {
+			return &fileLock{}, nil
+		}
+	} else {
+		fd, err = os.OpenFile(path+lockFileName, os.O_CREATE|os.O_RDWR, perm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(fd, shared); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &fileLock{fd: fd}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package bitcask
+
+import "os"
+
+// flock is not available, the lock file is created but not locked
+
+func lockFile(fd *os.File, shared bool) error {
+	return nil
+}
+
+func unlockFile(fd *os.File) error {
+	return nil
+}
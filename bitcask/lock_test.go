@@ -0,0 +1,66 @@
+//go:build unix
+
+package bitcask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Lock(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+
+	// a second writer or reader is rejected while the store is open
+	_, err = Open(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+	_, err = Open(dir, WithReadOnly(true))
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// the lock is released on close
+	assert.NoError(t, b.Close())
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Close())
+}
+
+func Test_LockReleasedOnFailedClose(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("key"), []byte("value")))
+	b.rotate(MaxFileSize)
+	sealed := b.files.get(1)
+
+	// the current file cannot be synced, the rest is closed all the same
+	assert.NoError(t, b.CurrentFile.Fd.Close())
+	assert.Error(t, b.Close())
+	assert.Nil(t, sealed.Fd)
+	assert.ErrorIs(t, b.Close(), ErrClosed)
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Close())
+}
+
+func Test_SharedLock(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Close())
+
+	// read-only openers share the lock and keep writers out
+	r1, err := Open(dir, WithReadOnly(true))
+	assert.NoError(t, err)
+	r2, err := Open(dir, WithReadOnly(true))
+	assert.NoError(t, err)
+	_, err = Open(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.NoError(t, r1.Close())
+	assert.NoError(t, r2.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Close())
+}
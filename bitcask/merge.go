@@ -0,0 +1,288 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergeDirName = "merge"
+	// mergeFinName marks a merge directory as complete, it holds the id of
+	// the first data file that was not merged and the number of merged files
+	mergeFinName = "MERGEFIN"
+)
+
+// movedEntry records where a live entry was copied to by the merge
+type movedEntry struct {
+	entry     *Entry
+	oldFileID uint32
+	oldPos    uint64
+	newFileID uint32
+	newPos    uint64
+}
+
+// Merge rewrites the live records of all immutable data files into fresh
+// merged files and removes the old ones. The current file is left alone and
+// keeps accepting writes while the merged files are written, mu is only held
+// to check the memDB and to swap the files in at the end.
+//
+// Merged files are numbered from 1, they are written to a merge directory
+// first and only moved into place once complete, so a crash during the merge
+// either leaves the store as it was or is finished on the next open (see
+// recoverMerge).
+func (b *Bitcask) Merge() error {
+	if b.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	b.mergeMu.Lock()
+	defer b.mergeMu.Unlock()
+
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrClosed
+	}
+	firstUnmerged := b.currentFileID
+	var sealed []*File
+	for _, f := range b.files.list() {
+		if f.FileID < firstUnmerged {
+			sealed = append(sealed, f)
+			// keep the mapping until the copy is done, whoever closes the file
+			if m := f.mmap; m != nil {
+				m.acquire()
+				defer m.release()
+			}
+		}
+	}
+	b.mu.RUnlock()
+	if len(sealed) == 0 {
+		return nil
+	}
+
+	mergePath := b.Path + mergeDirName + "/"
+	if err := os.RemoveAll(mergePath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(mergePath, b.opts.DirMode); err != nil {
+		return err
+	}
+
+	var moved []movedEntry
+	var dropped []movedEntry // live but expired, not copied
+	var merged []*File
+	// a merge that fails before it is complete leaves nothing behind
+	complete := false
+	defer func() {
+		if complete {
+			return
+		}
+		for _, f := range merged {
+			if f.Fd != nil {
+				f.CloseFile()
+			}
+		}
+		os.RemoveAll(mergePath)
+	}()
+	var out *File
+	var hints [][]*Entry
+	for _, f := range sealed {
+		entries, err := f.ReadEntries()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			// only keep the records the memDB still points at
+			b.mu.RLock()
+			e := b.memDB.Search(entry)
+			live := e != nil && e.FileID == entry.FileID && e.ValuePos == entry.ValuePos
+			b.mu.RUnlock()
+			if !live {
+				continue
+			}
+			// expired records are dropped for good
+			if entry.Expired(now()) {
+				dropped = append(dropped, movedEntry{entry: e, oldFileID: entry.FileID, oldPos: entry.ValuePos})
+				continue
+			}
+			value, err := f.Read(entry.ValuePos, entry.ValueSize)
+			if err != nil {
+				return err
+			}
+			rec := newRecord(entry.TimeStamp, entry.Key, 0, value, 0, entry.Expiry)
+			size := rec.HeaderSize() + uint64(rec.KeySize) + rec.ValueSize
+			if out == nil || out.CurrentPos+size > b.opts.MaxFileSize {
+				nextID := uint32(1)
+				if out != nil {
+					nextID = out.FileID + 1
+				}
+				if nextID >= firstUnmerged {
+					return fmt.Errorf("merge: no file id left below %d", firstUnmerged)
+				}
+				out = b.newFile(nextID, mergePath)
+				if err := out.OpenFile(); err != nil {
+					return err
+				}
+				merged = append(merged, out)
+				hints = append(hints, nil)
+			}
+			if _, err := out.AppendRecord(rec); err != nil {
+				return err
+			}
+			hints[len(hints)-1] = append(hints[len(hints)-1], rec.Entry(out.FileID))
+			moved = append(moved, movedEntry{
+				entry:     e,
+				oldFileID: entry.FileID,
+				oldPos:    entry.ValuePos,
+				newFileID: out.FileID,
+				newPos:    rec.ValuePos,
+			})
+		}
+	}
+	for i, f := range merged {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		if err := f.CloseFile(); err != nil {
+			return err
+		}
+		if err := WriteHintFile(mergePath, f.FileID, hints[i], b.opts.FileMode); err != nil {
+			return err
+		}
+	}
+	if err := writeMergeFin(mergePath, firstUnmerged, uint32(len(merged)), b.opts.FileMode); err != nil {
+		return err
+	}
+	complete = true
+
+	// swap the merged files in
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		// the completed merge is moved into place by the next Open
+		return ErrClosed
+	}
+	for _, f := range sealed {
+		b.files.remove(f.FileID)
+		// merged files reuse the ids, the locations now hold other records
+		b.cache.removeFile(f.FileID)
+		if err := f.CloseFile(); err != nil {
+			return err
+		}
+	}
+	if err := recoverMerge(b.Path); err != nil {
+		return err
+	}
+	for _, m := range merged {
+		f := b.newFile(m.FileID, b.Path)
+		if err := f.OpenFile(); err != nil {
+			return err
+		}
+		if b.opts.Mmap {
+			f.Map()
+		}
+		b.files.add(f)
+	}
+
+	// entries written again during the merge keep their new location
+	for _, m := range moved {
+		if m.entry.FileID == m.oldFileID && m.entry.ValuePos == m.oldPos {
+			m.entry.FileID = m.newFileID
+			m.entry.ValuePos = m.newPos
+		}
+	}
+	// the files the expired entries point at are gone, their ids may now be
+	// merged files
+	for _, m := range dropped {
+		if m.entry.FileID == m.oldFileID && m.entry.ValuePos == m.oldPos {
+			b.memDB.Delete(m.entry)
+		}
+	}
+	return nil
+}
+
+func writeMergeFin(mergePath string, firstUnmerged, mergedCount uint32, perm os.FileMode) error {
+	fd, err := os.OpenFile(mergePath+mergeFinName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fd, "%d\n%d\n", firstUnmerged, mergedCount); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// pendingMerge reports whether path holds a completed merge that has not
+// been moved into place yet
+func pendingMerge(path string) bool {
+	_, err := os.Stat(path + mergeDirName + "/" + mergeFinName)
+	return err == nil
+}
+
+// recoverMerge moves the files of a completed merge into path, replacing the
+// data files they were merged from. An incomplete merge is thrown away.
+func recoverMerge(path string) error {
+	mergePath := path + mergeDirName + "/"
+	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+		return nil
+	}
+	data, err := os.ReadFile(mergePath + mergeFinName)
+	if os.IsNotExist(err) {
+		return os.RemoveAll(mergePath)
+	}
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return fmt.Errorf("merge: bad %s", mergeFinName)
+	}
+	firstUnmerged, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return fmt.Errorf("merge: bad %s: %w", mergeFinName, err)
+	}
+	mergedCount, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return fmt.Errorf("merge: bad %s: %w", mergeFinName, err)
+	}
+
+	// merged files are 1..mergedCount, renaming replaces the old file with
+	// the same id. Files already moved by an interrupted recovery are gone
+	// from the merge directory, so this can safely run again.
+	mergedIDs, err := ScanDir(mergePath)
+	if err != nil {
+		return err
+	}
+	for _, fileID := range mergedIDs {
+		// the hint goes first, a data file in place always has its own hint
+		// next to it once the merge directory is gone
+		if err := os.Rename(hintName(mergePath, fileID), hintName(path, fileID)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := NewFile(fileID, mergePath).Rename(NewFile(fileID, path).Name()); err != nil {
+			return err
+		}
+	}
+	// then remove the rest of the data files that were merged
+	fileIDs, err := ScanDir(path)
+	if err != nil {
+		return err
+	}
+	for _, fileID := range fileIDs {
+		if fileID > uint32(mergedCount) && fileID < uint32(firstUnmerged) {
+			if err := NewFile(fileID, path).Delete(); err != nil {
+				return err
+			}
+			if err := RemoveHintFile(path, fileID); err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(mergePath)
+}
@@ -0,0 +1,136 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Merge(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBitcask(dir)
+	b.Open()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	// force a new data file
+	b.rotate(MaxFileSize)
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("new_value_%d", i))))
+	}
+	for i := 90; i < 100; i++ {
+		assert.NoError(t, b.Delete([]byte(fmt.Sprintf("key_%d", i))))
+	}
+	b.rotate(MaxFileSize)
+	assert.NoError(t, b.Put([]byte("active"), []byte("value")))
+
+	assert.NoError(t, b.Merge())
+	assert.Equal(t, []uint32{1, 3}, b.FileIDs())
+	_, err := os.Stat(dir + "/2.data")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(dir + "/" + mergeDirName)
+	assert.True(t, os.IsNotExist(err))
+
+	check := func(b *Bitcask) {
+		for i := 0; i < 100; i++ {
+			v, err := b.Get([]byte(fmt.Sprintf("key_%d", i)))
+			if i >= 90 {
+				assert.ErrorIs(t, err, ErrNotFound)
+				continue
+			}
+			assert.NoError(t, err)
+			if i < 50 {
+				assert.Equal(t, fmt.Sprintf("new_value_%d", i), string(v.Value))
+			} else {
+				assert.Equal(t, fmt.Sprintf("value_%d", i), string(v.Value))
+			}
+		}
+		v, err := b.Get([]byte("active"))
+		assert.NoError(t, err)
+		assert.Equal(t, "value", string(v.Value))
+	}
+	check(b)
+	// the current file keeps accepting writes
+	assert.NoError(t, b.Put([]byte("after"), []byte("merge")))
+	b.Close()
+
+	b = NewBitcask(dir)
+	b.Open()
+	check(b)
+	v, err := b.Get([]byte("after"))
+	assert.NoError(t, err)
+	assert.Equal(t, "merge", string(v.Value))
+	b.Close()
+}
+
+func Test_MergeIncomplete(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBitcask(dir)
+	b.Open()
+	assert.NoError(t, b.Put([]byte("key"), []byte("value")))
+	b.Close()
+
+	// a merge directory without the finish marker is discarded
+	mergePath := dir + "/" + mergeDirName + "/"
+	assert.NoError(t, os.MkdirAll(mergePath, os.ModePerm))
+	f := NewFile(1, mergePath)
+	assert.NoError(t, f.OpenFile())
+	_, err := f.WriteRecord([]byte("key"), []byte("stale"))
+	assert.NoError(t, err)
+	f.CloseFile()
+
+	b = NewBitcask(dir)
+	b.Open()
+	v, err := b.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(v.Value))
+	b.Close()
+	_, err = os.Stat(mergePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_MergeClose(t *testing.T) {
+	// close while merging, the merge finishes first or sees the store closed.
+	// Every other run maps the sealed files, they stay mapped while merged.
+	for n := 0; n < 20; n++ {
+		b, err := Open(t.TempDir(), WithMaxFileSize(FileHeaderSize+4*(RecordSize+16)), WithMmap(n%2 == 1))
+		assert.NoError(t, err)
+		for i := 0; i < 200; i++ {
+			assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i%20)), []byte(fmt.Sprintf("value_%d", i))))
+		}
+		done := make(chan error)
+		go func() { done <- b.Merge() }()
+		runtime.Gosched()
+		assert.NoError(t, b.Close())
+		if err := <-done; err != nil {
+			assert.ErrorIs(t, err, ErrClosed)
+		}
+	}
+}
+
+func Test_MergeFailure(t *testing.T) {
+	// legacy records grow in the current format, the three of file 1 need
+	// two merged files and only id 1 is free
+	dir := t.TempDir()
+	writeLegacyFile(t, dir, 1,
+		NewRecord(1, []byte("a"), 0, []byte("value_a")),
+		NewRecord(1, []byte("b"), 0, []byte("value_b")),
+		NewRecord(1, []byte("c"), 0, []byte("value_c")),
+	)
+	b, err := Open(dir, WithMaxFileSize(FileHeaderSize+2*(RecordSize+1+7)))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2}, b.FileIDs())
+	assert.Error(t, b.Merge())
+	_, err = os.Stat(dir + "/" + mergeDirName)
+	assert.True(t, os.IsNotExist(err))
+
+	// the store is as it was
+	v, err := b.Get([]byte("c"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "value_c", string(v.Value))
+	}
+	assert.NoError(t, b.Close())
+}
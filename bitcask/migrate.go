@@ -0,0 +1,300 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// migrateSuffix names the directory Migrate writes the new files to, next
+	// to the data directory
+	migrateSuffix = ".migrate"
+	// backupSuffix names the directory the original files are moved to
+	backupSuffix = ".premigrate"
+)
+
+// MigrateReport tells what Migrate did
+type MigrateReport struct {
+	Files      int    // data files read
+	Records    int    // live records written in the current format
+	Bytes      uint64 // size of the new data files
+	BackupPath string // where the original directory was moved to
+}
+
+// Migrate rewrites the store at path in the current format, for data
+// directories written by older versions of this package. It must not be open.
+//
+// The data files are read with File.ReadEntry, in any format this package
+// knows, and the live records are written in key order to a new directory
+// next to path. The new directory is then opened read-only and checked: it
+// must hold the same number of keys and every value must read back with the
+// checksum it was written with. Only then is path moved to path+".premigrate"
+// and the new directory moved to path. The backup is kept, remove it once the
+// migrated store has been checked. A crash between the two moves leaves no
+// directory at path, the next Migrate or read-write Open finishes the swap,
+// see finishMigrate.
+//
+// opts apply to the new files, MaxFileSize and the size limits in particular.
+func Migrate(path string, opts ...Option) (*MigrateReport, error) {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	if options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	// every record is checked while it is copied
+	options.SkipChecksum = false
+	path = strings.TrimSuffix(path, "/")
+	if err := finishMigrate(path); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	backupPath := path + backupSuffix
+	if _, err := os.Stat(backupPath); err == nil {
+		return nil, fmt.Errorf("migrate: %s already exists", backupPath)
+	}
+	src := path + "/"
+	lock, err := acquireLock(src, false, options.FileMode)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+	// finish a merge first, like Open does
+	if err := recoverMerge(src); err != nil {
+		return nil, err
+	}
+
+	// rebuild the memDB of the old files without touching them
+	b := &Bitcask{
+		Path:  src,
+		files: newFileRegistry(),
+		memDB: NewSkipListArr(),
+		opts:  options,
+	}
+	defer func() {
+		for _, f := range b.files.list() {
+			f.CloseFile()
+		}
+	}()
+	fileIDs, err := ScanDir(src)
+	if err != nil {
+		return nil, err
+	}
+	report := &MigrateReport{Files: len(fileIDs)}
+	for _, fileID := range fileIDs {
+		f := b.newFile(fileID, src)
+		f.ReadOnly = true
+		if err := f.OpenFile(); err != nil {
+			return nil, err
+		}
+		b.files.add(f)
+		entries, err := f.ReadEntries()
+		if err != nil {
+			return nil, err
+		}
+		// deletions, the empty legacy records included, leave the key out
+		for _, entry := range entries {
+			b.apply(entry)
+		}
+	}
+
+	dstPath := path + migrateSuffix + "/"
+	if err := os.RemoveAll(dstPath); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dstPath, options.DirMode); err != nil {
+		return nil, err
+	}
+	// records expired by now are dropped for good
+	t := now()
+	sums, err := b.migrateTo(dstPath, t, report)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.verifyMigrated(dstPath, t, sums); err != nil {
+		return nil, err
+	}
+
+	// swap the directories, a failure in between leaves both complete
+	if err := syncDir(dstPath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(path, backupPath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(strings.TrimSuffix(dstPath, "/"), path); err != nil {
+		if err2 := os.Rename(backupPath, path); err2 != nil {
+			return nil, fmt.Errorf("migrate: %v, the original files are in %s", err, backupPath)
+		}
+		return nil, err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	report.BackupPath = backupPath
+	return report, nil
+}
+
+// migrateTo writes the live records of the memDB to dstPath in the current
+// format with their hint files, skipping the records expired at t. It returns
+// the CRC of every value written in memDB order.
+func (b *Bitcask) migrateTo(dstPath string, t time.Time, report *MigrateReport) ([]uint32, error) {
+	var sums []uint32
+	var out *File
+	var hints []*Entry
+	seal := func() error {
+		if err := out.Sync(); err != nil {
+			return err
+		}
+		report.Bytes += out.CurrentPos
+		if err := out.CloseFile(); err != nil {
+			return err
+		}
+		return WriteHintFile(dstPath, out.FileID, hints, b.opts.FileMode)
+	}
+	var err error
+	b.memDB.Walk(func(e *Entry) bool {
+		if e.Expired(t) {
+			return true
+		}
+		var old *Record
+		// reading the whole record checks it against its CRC
+		if old, err = b.readRecord(e, false); err != nil {
+			return false
+		}
+		rec := newRecord(e.TimeStamp, e.Key, 0, old.Value, 0, e.Expiry)
+		if err = b.checkSize(rec); err != nil {
+			return false
+		}
+		size := rec.HeaderSize() + uint64(rec.KeySize) + rec.ValueSize
+		if out == nil || out.CurrentPos+size > b.opts.MaxFileSize {
+			fileID := uint32(1)
+			if out != nil {
+				fileID = out.FileID + 1
+				if err = seal(); err != nil {
+					return false
+				}
+			}
+			out = b.newFile(fileID, dstPath)
+			if err = out.OpenFile(); err != nil {
+				return false
+			}
+			hints = nil
+		}
+		if _, err = out.AppendRecord(rec); err != nil {
+			return false
+		}
+		hints = append(hints, rec.Entry(out.FileID))
+		sums = append(sums, crc32.ChecksumIEEE(rec.Value))
+		report.Records++
+		return true
+	})
+	if err != nil {
+		if out != nil && out.Fd != nil {
+			out.CloseFile()
+		}
+		return nil, err
+	}
+	if out != nil {
+		if err := seal(); err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+// verifyMigrated opens the store written by migrateTo and checks it holds the
+// records of the memDB not expired at t, with the value checksums in sums
+func (b *Bitcask) verifyMigrated(dstPath string, t time.Time, sums []uint32) error {
+	dst, err := Open(dstPath, WithReadOnly(true),
+		WithMaxKeySize(b.opts.MaxKeySize), WithMaxValueSize(b.opts.MaxValueSize))
+	if err != nil {
+		return fmt.Errorf("migrate: reopening the new files: %w", err)
+	}
+	defer dst.Close()
+	i, found := 0, 0
+	b.memDB.Walk(func(e *Entry) bool {
+		if e.Expired(t) {
+			return true
+		}
+		sum := sums[i]
+		i++
+		var rec *Record
+		rec, err = dst.Get(e.Key)
+		if errors.Is(err, ErrNotFound) && e.Expired(now()) {
+			// expired since it was written
+			err = nil
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		if crc32.ChecksumIEEE(rec.Value) != sum || rec.TimeStamp != e.TimeStamp || rec.Expiry != e.Expiry ||
+			!bytes.Equal(rec.Key, e.Key) {
+			err = fmt.Errorf("migrate: key %q does not match: %w", e.Key, ErrChecksum)
+			return false
+		}
+		found++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	// keys that expired since they were written may or may not be counted
+	if n, written := dst.Len(), len(sums); n < found || n > written {
+		return fmt.Errorf("migrate: wrote %d keys, the new files hold %d", written, n)
+	}
+	return nil
+}
+
+// migratePending reports whether a Migrate of path stopped between moving
+// the old files to the backup and the new ones into place
+func migratePending(path string) bool {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return false
+	}
+	for _, dir := range []string{path + backupSuffix, path + migrateSuffix} {
+		if _, err := os.Stat(dir); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// finishMigrate moves the new files of an interrupted Migrate into place, the
+// files verified by Migrate are in the migrate directory as long as the
+// backup exists
+func finishMigrate(path string) error {
+	if !migratePending(path) {
+		return nil
+	}
+	if err := os.Rename(path+migrateSuffix, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir fsyncs a directory so renames in it are durable
+func syncDir(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = fd.Sync()
+	if err2 := fd.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package bitcask
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Migrate(t *testing.T) {
+	dir := t.TempDir() + "/db"
+	assert.NoError(t, os.Mkdir(dir, 0755))
+	ts := time.Now().UnixNano()
+	expiry := now().Add(time.Hour).Unix()
+	writeLegacyFile(t, dir, 1,
+		NewRecord(ts, []byte("a"), 0, []byte("value_a")),
+		NewRecord(ts, []byte("b"), 0, []byte("value_b")),
+		NewTombstone(ts, []byte("a"), 0),
+		NewExpiringRecord(ts, []byte("c"), 0, []byte("value_c"), expiry),
+		NewExpiringRecord(ts, []byte("gone"), 0, []byte("value"), now().Add(-time.Hour).Unix()),
+	)
+	writeLegacyFile(t, dir, 2,
+		NewRecord(ts, []byte("b"), 0, []byte("new_value_b")),
+		NewRecord(ts, []byte("d"), 0, []byte("value_d")),
+		NewRecord(ts, []byte("e"), 0, []byte("value_e")),
+		// a deletion in the legacy format, without the tombstone flag
+		NewRecord(ts, []byte("e"), 0, nil),
+	)
+	old, err := os.ReadFile(dir + "/1.data")
+	assert.NoError(t, err)
+
+	// the store has to be closed
+	b, err := Open(dir, WithReadOnly(true))
+	assert.NoError(t, err)
+	b.Close()
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	_, err = Migrate(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.NoError(t, b.Close())
+	// Open started a new file, the legacy ones are left as they were
+	ids, err := ScanDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 3}, ids)
+
+	report, err := Migrate(dir, WithMaxFileSize(FileHeaderSize+RecordSize+ExpirySize+8))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3, report.Files)
+	assert.Equal(t, 3, report.Records)
+	assert.Equal(t, dir+".premigrate", report.BackupPath)
+	backup, err := os.ReadFile(report.BackupPath + "/1.data")
+	assert.NoError(t, err)
+	assert.Equal(t, old, backup)
+	_, err = os.Stat(dir + ".migrate")
+	assert.True(t, os.IsNotExist(err))
+
+	// one record per file, all in the current format with a hint
+	ids, err = ScanDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 3}, ids)
+	var size uint64
+	for _, id := range ids {
+		f := NewFile(id, dir+"/")
+		f.ReadOnly = true
+		assert.NoError(t, f.OpenFile())
+		assert.Equal(t, FormatVersion, f.Version)
+		size += f.FileSize
+		f.CloseFile()
+		_, err := ReadHintFile(dir+"/", id)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, size, report.Bytes)
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.Equal(t, 3, b.Len())
+	for k, v := range map[string]string{"b": "new_value_b", "c": "value_c", "d": "value_d"} {
+		rec, err := b.Get([]byte(k))
+		if assert.NoError(t, err, k) {
+			assert.Equal(t, v, string(rec.Value), k)
+			assert.Equal(t, ts/int64(time.Second)*int64(time.Second), rec.TimeStamp, k)
+		}
+	}
+	rec, err := b.Get([]byte("c"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, expiry, rec.Expiry)
+	}
+	_, err = b.Get([]byte("e"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	setNow(t, 2*time.Hour)
+	_, err = b.Get([]byte("c"))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_MigrateErrors(t *testing.T) {
+	dir := t.TempDir() + "/db"
+	_, err := Migrate(dir)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, os.Mkdir(dir, 0755))
+	writeLegacyFile(t, dir, 1, NewRecord(1, []byte("key"), 0, []byte("value")))
+	// the value does not fit the new limit, nothing is moved
+	_, err = Migrate(dir, WithMaxValueSize(2))
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	ids, err := ScanDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1}, ids)
+	_, err = os.Stat(dir + ".premigrate")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = Migrate(dir)
+	assert.NoError(t, err)
+	// the backup of the first migration is never overwritten
+	_, err = Migrate(dir)
+	assert.Error(t, err)
+}
+
+func Test_MigrateInterrupted(t *testing.T) {
+	dir := t.TempDir() + "/db"
+	assert.NoError(t, os.Mkdir(dir, 0755))
+	writeLegacyFile(t, dir, 1, NewRecord(1, []byte("key"), 0, []byte("value")))
+	_, err := Migrate(dir)
+	assert.NoError(t, err)
+	// as if Migrate stopped between moving the old files away and the new
+	// ones into place
+	assert.NoError(t, os.Rename(dir, dir+".migrate"))
+
+	_, err = Open(dir, WithReadOnly(true))
+	assert.ErrorIs(t, err, ErrMigratePending)
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	v, err := b.Get([]byte("key"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "value", string(v.Value))
+	}
+	assert.Equal(t, FormatVersion, b.files.get(1).Version)
+	assert.NoError(t, b.Close())
+	_, err = os.Stat(dir + ".migrate")
+	assert.True(t, os.IsNotExist(err))
+
+	// Migrate finishes it too, then finds the backup of the first run
+	assert.NoError(t, os.Rename(dir, dir+".migrate"))
+	_, err = Migrate(dir)
+	assert.Error(t, err)
+	_, err = os.Stat(dir + "/1.data")
+	assert.NoError(t, err)
+}
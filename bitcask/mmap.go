@@ -0,0 +1,95 @@
+package bitcask
+
+import (
+	"io"
+	"sync"
+)
+
+// mapping is a read-only memory mapping of a sealed data file. Records read
+// with File.ViewRecord point into it, so it is only unmapped once the file is
+// closed and every view has been released.
+type mapping struct {
+	mu     sync.Mutex
+	data   []byte
+	refs   int
+	closed bool
+}
+
+func (m *mapping) acquire() {
+	m.mu.Lock()
+	m.refs++
+	m.mu.Unlock()
+}
+
+func (m *mapping) release() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refs--
+	return m.unmap()
+}
+
+func (m *mapping) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return m.unmap()
+}
+
+// unmap unmaps the data once it is no longer used, mu must be held
+func (m *mapping) unmap() error {
+	if !m.closed || m.refs > 0 || m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return munmap(data)
+}
+
+// Map maps the file into memory, Read then copies from the mapping rather
+// than reading the file and ViewRecord returns records pointing into it. It
+// is only for files that are no longer written to, what is written after Map
+// is not seen.
+func (f *File) Map() error {
+	if f.mmap != nil || f.FileSize == 0 {
+		return nil
+	}
+	data, err := mmap(f.Fd, int(f.FileSize))
+	if err != nil {
+		return &FileError{FileID: f.FileID, Err: err}
+	}
+	f.mmap = &mapping{data: data}
+	return nil
+}
+
+// ViewRecord is ReadRecord without copying for a mapped file: the Key and
+// Value of the record are slices of the mapping, valid until release is
+// called and never to be modified. Without verify only the header and key are
+// checked against the entry, not the CRC, and the record has no Crc. Records
+// of a file that is not mapped are read with ReadRecord and release does
+// nothing.
+func (f *File) ViewRecord(entry *Entry, verify bool) (rec *Record, release func(), err error) {
+	m := f.mmap
+	if m == nil {
+		rec, err := f.ReadRecord(entry)
+		return rec, func() {}, err
+	}
+	offset, size, err := f.recordSpan(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset+size > uint64(len(m.data)) {
+		return nil, nil, &FileError{FileID: f.FileID, Offset: int64(offset), Err: io.ErrUnexpectedEOF}
+	}
+	buf := m.data[offset : offset+size : offset+size]
+	if verify {
+		rec, err = f.decodeAt(entry, offset, buf)
+	} else {
+		rec, err = f.checkAt(entry, offset, buf)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	m.acquire()
+	var once sync.Once
+	return rec, func() { once.Do(func() { m.release() }) }, nil
+}
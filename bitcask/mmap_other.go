@@ -0,0 +1,18 @@
+//go:build !unix
+
+package bitcask
+
+import (
+	"errors"
+	"os"
+)
+
+// mmap is not available, files are read with ReadAt
+
+func mmap(fd *os.File, size int) ([]byte, error) {
+	return nil, errors.New("bitcask: mmap is not supported on this platform")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,105 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// inMapping reports whether data points into the mapping of f
+func inMapping(f *File, data []byte) bool {
+	if f.mmap == nil || len(data) == 0 {
+		return false
+	}
+	start := uintptr(unsafe.Pointer(&f.mmap.data[0]))
+	p := uintptr(unsafe.Pointer(&data[0]))
+	return p >= start && p < start+uintptr(len(f.mmap.data))
+}
+
+func Test_Mmap(t *testing.T) {
+	// three 41 byte records per file
+	const recSize = RecordSize + 5 + 7
+	dir := t.TempDir()
+	b, err := Open(dir, WithMaxFileSize(FileHeaderSize+3*recSize), WithMmap(true))
+	assert.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	// sealed files are mapped as they are sealed, not the current one
+	assert.NotNil(t, b.files.get(1).mmap)
+	assert.NotNil(t, b.files.get(2).mmap)
+	assert.Nil(t, b.files.get(3).mmap)
+	for i := 0; i < 8; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		rec, err := b.Get(key)
+		if assert.NoError(t, err) {
+			assert.Equal(t, fmt.Sprintf("value_%d", i), string(rec.Value))
+			assert.False(t, inMapping(b.files.get(uint32(i/3+1)), rec.Value))
+		}
+		rec, release, err := b.GetNoCopy(key)
+		if assert.NoError(t, err) {
+			assert.Equal(t, fmt.Sprintf("value_%d", i), string(rec.Value))
+			assert.Equal(t, string(key), string(rec.Key))
+			assert.Equal(t, i < 6, inMapping(b.files.get(uint32(i/3+1)), rec.Value))
+			release()
+			release()
+		}
+	}
+	_, _, err = b.GetNoCopy([]byte("missing"))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// a view outlives the file being merged away and the store being closed
+	rec, release, err := b.GetNoCopy([]byte("key_0"))
+	assert.NoError(t, err)
+	m := b.files.get(1).mmap
+	m2 := b.files.get(2).mmap
+	assert.NoError(t, b.Merge())
+	assert.Equal(t, 0, m2.refs)
+	assert.Nil(t, m2.data)
+	assert.NotSame(t, m, b.files.get(1).mmap)
+	assert.NotNil(t, b.files.get(1).mmap)
+	assert.NoError(t, b.Close())
+	assert.Equal(t, "value_0", string(rec.Value))
+	assert.NotNil(t, m.data)
+	release()
+	assert.Nil(t, m.data)
+	_, _, err = b.GetNoCopy([]byte("key_0"))
+	assert.ErrorIs(t, err, ErrClosed)
+
+	// mapped files are checked like the others
+	b, err = Open(dir, WithMaxFileSize(FileHeaderSize+3*recSize), WithMmap(true), WithReplayWorkers(2))
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.NotNil(t, b.files.get(1).mmap)
+	fd, err := os.OpenFile(dir+"/1.data", os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = fd.WriteAt([]byte{'X'}, FileHeaderSize+recSize-1)
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+	_, _, err = b.GetNoCopy([]byte("key_0"))
+	assert.ErrorIs(t, err, ErrChecksum)
+	_, err = b.Get([]byte("key_0"))
+	assert.ErrorIs(t, err, ErrChecksum)
+}
+
+func Test_MmapFailedRotate(t *testing.T) {
+	// three 41 byte records per file
+	const recSize = RecordSize + 5 + 7
+	dir := t.TempDir()
+	b, err := Open(dir, WithMaxFileSize(FileHeaderSize+3*recSize), WithMmap(true))
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.NoError(t, b.Put([]byte("key_0"), []byte("value_0")))
+	// the next file cannot be created, the current one is not mapped
+	assert.NoError(t, os.Mkdir(dir+"/2.data", 0755))
+	assert.Error(t, b.Put([]byte("large"), make([]byte, 2*recSize)))
+	assert.Nil(t, b.CurrentFile.mmap)
+	assert.NoError(t, b.Put([]byte("key_1"), []byte("value_1")))
+	v, err := b.Get([]byte("key_1"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "value_1", string(v.Value))
+	}
+}
@@ -0,0 +1,192 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// SyncPolicy controls when writes are flushed to disk
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs the current file after every write
+	SyncAlways SyncPolicy = iota
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+	// SyncInterval fsyncs the current file every Options.SyncInterval
+	SyncInterval
+	// SyncBytes fsyncs the current file once Options.SyncBytes bytes have
+	// been written since the last sync
+	SyncBytes
+	// SyncGroupCommit fsyncs before every write returns like SyncAlways, but
+	// concurrent writers waiting at the same time share one fsync
+	SyncGroupCommit
+)
+
+type Options struct {
+	MaxFileSize   uint64        // a new data file is started once the current one would exceed this size
+	SyncPolicy    SyncPolicy    // when to fsync the current file
+	SyncInterval  time.Duration // for SyncInterval
+	SyncBytes     uint64        // for SyncBytes
+	DirMode       os.FileMode   // permissions of a newly created data directory
+	FileMode      os.FileMode   // permissions of newly created data and hint files
+	ReadOnly      bool          // open files O_RDONLY, reject writes and never modify the data directory
+	SkipChecksum  bool          // Get reads only the value and does not check it against its record
+	MaxKeySize    uint32        // longest key accepted by writes and reads
+	MaxValueSize  uint64        // longest value accepted by writes and reads
+	ReplayWorkers int           // data files Open reads at once
+	Mmap          bool          // read sealed data files through a memory mapping
+	CacheSize     uint64        // bytes of records read by Get kept in memory, 0 for no cache
+}
+
+type Option func(*Options)
+
+func DefaultOptions() Options {
+	return Options{
+		MaxFileSize:   MaxFileSize,
+		SyncPolicy:    SyncAlways,
+		DirMode:       os.ModePerm,
+		FileMode:      0644,
+		MaxKeySize:    MaxKeySize,
+		MaxValueSize:  MaxValueSize,
+		ReplayWorkers: runtime.GOMAXPROCS(0),
+	}
+}
+
+func WithMaxFileSize(size uint64) Option {
+	return func(o *Options) {
+		o.MaxFileSize = size
+	}
+}
+
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(o *Options) {
+		o.SyncPolicy = policy
+	}
+}
+
+// WithSyncInterval fsyncs the current file every interval
+func WithSyncInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.SyncPolicy = SyncInterval
+		o.SyncInterval = interval
+	}
+}
+
+// WithSyncBytes fsyncs the current file every n bytes written
+func WithSyncBytes(n uint64) Option {
+	return func(o *Options) {
+		o.SyncPolicy = SyncBytes
+		o.SyncBytes = n
+	}
+}
+
+// WithGroupCommit makes every write durable before it returns, sharing the
+// fsync between concurrent writers
+func WithGroupCommit() Option {
+	return func(o *Options) {
+		o.SyncPolicy = SyncGroupCommit
+	}
+}
+
+func WithDirMode(mode os.FileMode) Option {
+	return func(o *Options) {
+		o.DirMode = mode
+	}
+}
+
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *Options) {
+		o.FileMode = mode
+	}
+}
+
+// WithReadOnly opens the store for reading only: nothing in the data
+// directory is created, truncated or rewritten, and Put, Delete, Write and
+// Merge return ErrReadOnly. The store sees the data as of Open, several
+// read-only openers can share a directory while no writer has it open.
+func WithReadOnly(readOnly bool) Option {
+	return func(o *Options) {
+		o.ReadOnly = readOnly
+	}
+}
+
+// WithSkipChecksum makes Get and Scan read only the value bytes instead of
+// the whole record, saving the read of the header and key and the CRC for
+// hot paths at the cost of returning corrupted data undetected. Open and
+// Merge still check every record they read.
+func WithSkipChecksum(skip bool) Option {
+	return func(o *Options) {
+		o.SkipChecksum = skip
+	}
+}
+
+// WithMaxKeySize limits the size of keys. Writes of a longer key fail with
+// ErrKeyTooLarge, and so does Open on a record with a longer key, which is
+// taken as a sign of corruption rather than trusted to allocate memory.
+func WithMaxKeySize(size uint32) Option {
+	return func(o *Options) {
+		o.MaxKeySize = size
+	}
+}
+
+// WithMaxValueSize limits the size of values like WithMaxKeySize does for
+// keys, with ErrValueTooLarge.
+func WithMaxValueSize(size uint64) Option {
+	return func(o *Options) {
+		o.MaxValueSize = size
+	}
+}
+
+// WithReplayWorkers sets how many data files Open reads and decodes at once,
+// the default is GOMAXPROCS. The files are still applied in order.
+func WithReplayWorkers(n int) Option {
+	return func(o *Options) {
+		o.ReplayWorkers = n
+	}
+}
+
+// WithMmap maps sealed data files into memory: reads from them copy from the
+// mapping instead of making a system call, and GetNoCopy returns their values
+// without copying. The current file is still read with ReadAt. Files that
+// cannot be mapped are read as usual.
+func WithMmap(mmap bool) Option {
+	return func(o *Options) {
+		o.Mmap = mmap
+	}
+}
+
+// WithCacheSize keeps the records most recently read by Get in memory, up to
+// size bytes of keys and values plus a small overhead per record. Overwritten
+// and deleted records are dropped from the cache, scans use it but do not add
+// to it. See Bitcask.CacheStats.
+func WithCacheSize(size uint64) Option {
+	return func(o *Options) {
+		o.CacheSize = size
+	}
+}
+
+func (o *Options) validate() error {
+	switch o.SyncPolicy {
+	case SyncAlways, SyncNever, SyncGroupCommit:
+	case SyncInterval:
+		if o.SyncInterval <= 0 {
+			return fmt.Errorf("bitcask: sync interval must be positive, got %s", o.SyncInterval)
+		}
+	case SyncBytes:
+		if o.SyncBytes == 0 {
+			return fmt.Errorf("bitcask: sync bytes must be positive")
+		}
+	default:
+		return fmt.Errorf("bitcask: unknown sync policy %d", o.SyncPolicy)
+	}
+	if o.MaxKeySize == 0 || o.MaxValueSize == 0 {
+		return fmt.Errorf("bitcask: max key and value sizes must be positive")
+	}
+	if o.ReplayWorkers <= 0 {
+		return fmt.Errorf("bitcask: replay workers must be positive, got %d", o.ReplayWorkers)
+	}
+	return nil
+}
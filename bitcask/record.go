@@ -1,90 +1,128 @@
 package bitcask
 
-import (
-	"encoding/binary"
-	"fmt"
-	"hash/crc32"
+import "time"
+
+const (
+	// FlagTombstone marks a record that deletes its key.
+	FlagTombstone uint8 = 1 << 0
+	// FlagBatch marks a record written by a WriteBatch, it only takes effect
+	// once the batch commit record that follows it is read.
+	FlagBatch uint8 = 1 << 1
+	// FlagBatchCommit marks the record closing a WriteBatch, its key holds
+	// the number of records in the batch.
+	FlagBatchCommit uint8 = 1 << 2
+	// FlagExpiry marks a record whose header is followed by an expiry time
+	// in seconds, after which the key reads as missing.
+	FlagExpiry uint8 = 1 << 3
 )
 
 type RecordHeader struct {
-	Crc       uint32 // unit32 最大能表示5G数字， 所以uni32 已经够用
-	TimeStamp uint32 //unit32 时间戳，以秒计算，可以表示136年
+	HeaderCrc uint32 // only stored by version 3 records, covers the rest of the header
+	Crc       uint32
+	TimeStamp int64 // 纳秒时间戳
 	KeySize   uint32
-	ValueSize uint32
-	ValuePos  uint32 // value 在数据文件中的偏移位置, 每个文件不超过1GB， 所以使用uint32
+	ValueSize uint64
+	ValuePos  uint64 // only stored by legacy records
+	Flags     uint8
+	Expiry    int64 // only with FlagExpiry, read after the fixed header
 }
 
 type Record struct {
-	Crc       uint32 // unit32 最大能表示5G数字， 所以uni32 已经够用
-	TimeStamp uint32 //unit32 时间戳，以秒计算，可以表示136年
+	Crc       uint32
+	TimeStamp int64 // 纳秒时间戳
 	KeySize   uint32
-	ValueSize uint32
-	ValuePos  uint32 // value 在数据文件中的偏移位置, 每个文件不超过1GB， 所以使用uint32
+	ValueSize uint64
+	ValuePos  uint64 // value 在数据文件中的偏移位置
+	Flags     uint8
+	Expiry    int64 // 过期时间，以秒计算，0 表示不过期
 	Key       []byte
 	Value     []byte
 }
 
-func NewRecord(timeStamp uint32, key []byte, valuePos uint32, value []byte) *Record {
-	h := crc32.NewIEEE()
-	h.Write(key)
-	h.Write(value)
+func NewRecord(timeStamp int64, key []byte, valuePos uint64, value []byte) *Record {
+	return NewRecordWithFlags(timeStamp, key, valuePos, value, 0)
+}
+
+// NewTombstone creates a record that deletes key.
+func NewTombstone(timeStamp int64, key []byte, valuePos uint64) *Record {
+	return NewRecordWithFlags(timeStamp, key, valuePos, nil, FlagTombstone)
+}
+
+func NewRecordWithFlags(timeStamp int64, key []byte, valuePos uint64, value []byte, flags uint8) *Record {
+	return newRecord(timeStamp, key, valuePos, value, flags, 0)
+}
+
+// NewExpiringRecord creates a record that expires at expiry, in seconds
+func NewExpiringRecord(timeStamp int64, key []byte, valuePos uint64, value []byte, expiry int64) *Record {
+	return newRecord(timeStamp, key, valuePos, value, 0, expiry)
+}
+
+func newRecord(timeStamp int64, key []byte, valuePos uint64, value []byte, flags uint8, expiry int64) *Record {
+	if expiry != 0 {
+		flags |= FlagExpiry
+	}
 	return &Record{
-		Crc:       h.Sum32(),
+		Crc:       checksum(FormatVersion, flags, expiry, key, value),
 		TimeStamp: timeStamp,
 		KeySize:   uint32(len(key)),
-		ValueSize: uint32(len(value)),
+		ValueSize: uint64(len(value)),
 		ValuePos:  valuePos,
+		Flags:     flags,
+		Expiry:    expiry,
 		Key:       key,
 		Value:     value,
 	}
 }
 
-func (r *Record) Encode() []byte {
-	// bigendian
-	data := make([]byte, 4+4+4+4+4+len(r.Key)+len(r.Value))
-	binary.BigEndian.PutUint32(data[0:4], r.Crc)
-	binary.BigEndian.PutUint32(data[4:8], r.TimeStamp)
-	binary.BigEndian.PutUint32(data[8:12], r.KeySize)
-	binary.BigEndian.PutUint32(data[12:16], r.ValueSize)
-	binary.BigEndian.PutUint32(data[16:20], r.ValuePos)
-	copy(data[20:20+len(r.Key)], r.Key)
-	copy(data[20+len(r.Key):], r.Value)
-	return data
+// Entry returns the memDB entry of the record written to file fileID
+func (r *Record) Entry(fileID uint32) *Entry {
+	e := NewEntry(r.Key, fileID, r.ValueSize, r.ValuePos, r.TimeStamp)
+	e.Flags = r.Flags
+	e.Expiry = r.Expiry
+	return e
 }
-func Decode(data []byte) (*Record, error) {
-	crc := binary.BigEndian.Uint32(data[0:4])
-	timeStamp := binary.BigEndian.Uint32(data[4:8])
-	keySize := binary.BigEndian.Uint32(data[8:12])
-	valueSize := binary.BigEndian.Uint32(data[12:16])
-	valuePos := binary.BigEndian.Uint32(data[16:20])
 
-	key := make([]byte, keySize)
-	value := make([]byte, valueSize)
+// HeaderSize returns the size of the record header, the key follows it
+func (r *Record) HeaderSize() uint64 {
+	return headerSize(FormatVersion, r.Flags)
+}
 
-	copy(key, data[20:20+keySize])
-	copy(value, data[20+keySize:20+keySize+valueSize])
-	record := NewRecord(timeStamp, key, valuePos, value)
-	if crc != record.Crc {
+// Expired reports whether the record has an expiry time at or before now
+func (r *Record) Expired(now time.Time) bool {
+	return expired(r.Expiry, now)
+}
 
-		return nil, fmt.Errorf("crc32 check failed")
-	}
-	record.ValuePos = valuePos // Set ValuePos separately since it's not in constructor
-	return record, nil
+// now is the clock used for expiry, replaced in tests
+var now = time.Now
 
+func expired(expiry int64, now time.Time) bool {
+	return expiry != 0 && now.Unix() >= expiry
 }
-func DecodeHeader(data []byte) *RecordHeader {
-	crc := binary.BigEndian.Uint32(data[0:4])
-	timeStamp := binary.BigEndian.Uint32(data[4:8])
-	keySize := binary.BigEndian.Uint32(data[8:12])
-	valueSize := binary.BigEndian.Uint32(data[12:16])
-	valuePos := binary.BigEndian.Uint32(data[16:20])
-	return &RecordHeader{
-		Crc:       crc,
-		TimeStamp: timeStamp,
-		KeySize:   keySize,
-		ValueSize: valueSize,
-		ValuePos:  valuePos,
+
+// expiryAt returns the expiry of a record that must live until t, the
+// second after t unless t is a whole second so the record never expires early
+func expiryAt(t time.Time) int64 {
+	if t.Nanosecond() > 0 {
+		return t.Unix() + 1
 	}
+	return t.Unix()
+}
+
+func (r *Record) IsTombstone() bool {
+	return r.Flags&FlagTombstone != 0
+}
+
+// Encode encodes the record in the current format
+func (r *Record) Encode() []byte {
+	return encodeRecord(FormatVersion, r)
 }
 
-// bufio write records
+// Decode decodes a record in the current format and checks it
+func Decode(data []byte) (*Record, error) {
+	return decodeRecord(FormatVersion, data)
+}
+
+// DecodeHeader decodes the fixed header of a record in the current format
+func DecodeHeader(data []byte) *RecordHeader {
+	return decodeHeader(FormatVersion, data)
+}
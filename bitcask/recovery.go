@@ -0,0 +1,38 @@
+package bitcask
+
+// RecoveryReport tells what Open found while replaying the data files
+type RecoveryReport struct {
+	Records   int            // records replayed from data and hint files
+	Discarded uint64         // bytes cut off the end of the current file
+	Corrupt   []CorruptRange // torn records found at the end of data files
+}
+
+// CorruptRange is a part of a data file that holds no valid records
+type CorruptRange struct {
+	FileID uint32
+	Offset uint64
+	Size   uint64
+}
+
+// Recovery returns what Open found in the data files. Only a torn record at
+// the end of the current file, left by a crash during a write, is repaired by
+// cutting it off. One at the end of a sealed file is left in place and
+// reported, and corruption anywhere else makes Open fail.
+func (b *Bitcask) Recovery() RecoveryReport {
+	r := b.recovery
+	r.Corrupt = append([]CorruptRange(nil), r.Corrupt...)
+	return r
+}
+
+// tornTail records the torn record ReadEntries stopped at, if any. discard
+// tells whether Resume will cut it off.
+func (r *RecoveryReport) tornTail(f *File, discard bool) {
+	if f.CurrentPos >= f.FileSize {
+		return
+	}
+	size := f.FileSize - f.CurrentPos
+	r.Corrupt = append(r.Corrupt, CorruptRange{FileID: f.FileID, Offset: f.CurrentPos, Size: size})
+	if discard {
+		r.Discarded += size
+	}
+}
@@ -0,0 +1,97 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Recovery(t *testing.T) {
+	// three 41 byte records per file
+	const recSize = RecordSize + 5 + 7
+	dir := t.TempDir()
+	b, err := Open(dir, WithMaxFileSize(FileHeaderSize+3*recSize))
+	assert.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	assert.Equal(t, []uint32{1, 2, 3}, b.FileIDs())
+	assert.NoError(t, b.Close())
+	// make Open scan the sealed files
+	removeHints := func() {
+		assert.NoError(t, RemoveHintFile(dir+"/", 1))
+		assert.NoError(t, RemoveHintFile(dir+"/", 2))
+	}
+	removeHints()
+
+	flip := func(name string, offset int64) {
+		fd, err := os.OpenFile(dir+"/"+name, os.O_RDWR, 0)
+		assert.NoError(t, err)
+		defer fd.Close()
+		buf := make([]byte, 1)
+		_, err = fd.ReadAt(buf, offset)
+		assert.NoError(t, err)
+		buf[0] ^= 0xff
+		_, err = fd.WriteAt(buf, offset)
+		assert.NoError(t, err)
+	}
+	// the last byte of the value of the nth record
+	valueEnd := func(n int64) int64 { return FileHeaderSize + (n+1)*recSize - 1 }
+
+	// corruption in the middle of a sealed file fails Open and is left alone
+	before := dirState(t, dir)
+	flip("1.data", valueEnd(0))
+	_, err = Open(dir)
+	assert.ErrorIs(t, err, ErrChecksum)
+	var fileErr *FileError
+	if assert.ErrorAs(t, err, &fileErr) {
+		assert.Equal(t, uint32(1), fileErr.FileID)
+		assert.Equal(t, int64(FileHeaderSize), fileErr.Offset)
+	}
+	assert.Equal(t, before, dirState(t, dir))
+	flip("1.data", valueEnd(0))
+
+	// and so does corruption in the middle of the current file
+	flip("3.data", valueEnd(0))
+	_, err = Open(dir)
+	assert.ErrorIs(t, err, ErrChecksum)
+	removeHints()
+	assert.Equal(t, before, dirState(t, dir))
+	flip("3.data", valueEnd(0))
+
+	// a bad last record of a sealed file is reported but kept
+	flip("2.data", valueEnd(2))
+	b, err = Open(dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, RecoveryReport{
+		Records: 7,
+		Corrupt: []CorruptRange{{FileID: 2, Offset: uint64(valueEnd(1) + 1), Size: recSize}},
+	}, b.Recovery())
+	_, err = b.Get([]byte("key_5"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, b.Close())
+	flip("2.data", valueEnd(2))
+	removeHints()
+
+	// a write that never reached the disk leaves zeros at the end of the
+	// current file, they are cut off
+	fd, err := os.OpenFile(dir+"/3.data", os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = fd.Write(make([]byte, 100))
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, RecoveryReport{
+		Records:   8,
+		Discarded: 100,
+		Corrupt:   []CorruptRange{{FileID: 3, Offset: uint64(valueEnd(1) + 1), Size: 100}},
+	}, b.Recovery())
+	assert.NoError(t, b.Close())
+	removeHints()
+	assert.Equal(t, before, dirState(t, dir))
+}
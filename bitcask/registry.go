@@ -0,0 +1,72 @@
+package bitcask
+
+import "sort"
+
+// fileRegistry holds the open data files keyed by file id. The ids are not
+// contiguous: rotation adds the next id after the current file, while a merge
+// replaces the files it merged with fewer files numbered from 1, leaving a gap
+// before the files written since the merge started. It is guarded by
+// Bitcask.mu.
+type fileRegistry struct {
+	files map[uint32]*File
+	ids   []uint32 // sorted
+}
+
+func newFileRegistry() *fileRegistry {
+	return &fileRegistry{files: make(map[uint32]*File)}
+}
+
+// get returns the file with the given id, nil if there is none
+func (r *fileRegistry) get(fileID uint32) *File {
+	return r.files[fileID]
+}
+
+// add registers f, replacing the file with the same id if there is one
+func (r *fileRegistry) add(f *File) {
+	if _, ok := r.files[f.FileID]; !ok {
+		i := sort.Search(len(r.ids), func(i int) bool { return r.ids[i] >= f.FileID })
+		r.ids = append(r.ids, 0)
+		copy(r.ids[i+1:], r.ids[i:])
+		r.ids[i] = f.FileID
+	}
+	r.files[f.FileID] = f
+}
+
+// remove drops the file with the given id and returns it, nil if there is
+// none. The file is not closed.
+func (r *fileRegistry) remove(fileID uint32) *File {
+	f, ok := r.files[fileID]
+	if !ok {
+		return nil
+	}
+	delete(r.files, fileID)
+	i := sort.Search(len(r.ids), func(i int) bool { return r.ids[i] >= fileID })
+	r.ids = append(r.ids[:i], r.ids[i+1:]...)
+	return f
+}
+
+// IDs returns a copy of the file ids in order
+func (r *fileRegistry) IDs() []uint32 {
+	return append([]uint32(nil), r.ids...)
+}
+
+// list returns the files in id order
+func (r *fileRegistry) list() []*File {
+	files := make([]*File, 0, len(r.ids))
+	for _, id := range r.ids {
+		files = append(files, r.files[id])
+	}
+	return files
+}
+
+// last returns the file with the highest id, nil if there are no files
+func (r *fileRegistry) last() *File {
+	if len(r.ids) == 0 {
+		return nil
+	}
+	return r.files[r.ids[len(r.ids)-1]]
+}
+
+func (r *fileRegistry) len() int {
+	return len(r.ids)
+}
@@ -0,0 +1,78 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FileRegistry(t *testing.T) {
+	r := newFileRegistry()
+	assert.Nil(t, r.last())
+	for _, id := range []uint32{7, 2, 5} {
+		r.add(NewFile(id, ""))
+	}
+	assert.Equal(t, []uint32{2, 5, 7}, r.IDs())
+	assert.Equal(t, uint32(7), r.last().FileID)
+	assert.Nil(t, r.get(3))
+
+	// replacing keeps a single id
+	f := NewFile(5, "other/")
+	r.add(f)
+	assert.Equal(t, []uint32{2, 5, 7}, r.IDs())
+	assert.Same(t, f, r.get(5))
+
+	assert.Same(t, f, r.remove(5))
+	assert.Nil(t, r.remove(5))
+	assert.Nil(t, r.get(5))
+	assert.Equal(t, 2, r.len())
+	var ids []uint32
+	for _, f := range r.list() {
+		ids = append(ids, f.FileID)
+	}
+	assert.Equal(t, []uint32{2, 7}, ids)
+}
+
+func Test_NonContiguousFileIDs(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+		b.rotate(MaxFileSize)
+	}
+	assert.NoError(t, b.Close())
+	// leave gaps in the ids, as deleting files by hand or an old merge would
+	for _, ids := range [][2]int{{3, 9}, {2, 5}} {
+		assert.NoError(t, os.Rename(fmt.Sprintf("%s/%d.data", dir, ids[0]), fmt.Sprintf("%s/%d.data", dir, ids[1])))
+		os.Remove(fmt.Sprintf("%s/%d.hint", dir, ids[0]))
+	}
+
+	b, err = Open(dir, WithMaxFileSize(128))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 4, 5, 9}, b.FileIDs())
+	// rotation continues after the highest id
+	for i := 3; i < 6; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	assert.Equal(t, []uint32{1, 4, 5, 9, 10}, b.FileIDs())
+	check := func(b *Bitcask) {
+		for i := 0; i < 6; i++ {
+			v, err := b.Get([]byte(fmt.Sprintf("key_%d", i)))
+			if assert.NoError(t, err) {
+				assert.Equal(t, fmt.Sprintf("value_%d", i), string(v.Value))
+			}
+		}
+	}
+	check(b)
+	assert.NoError(t, b.Merge())
+	check(b)
+	assert.NoError(t, b.Close())
+
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	check(b)
+}
@@ -0,0 +1,258 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	repairDirName = "repair"
+	// corruptSuffix is added to the name of a data file kept after Repair
+	// rewrote it, ScanDir does not see it
+	corruptSuffix = ".corrupt"
+	// resyncWindow is how many offsets resync checks per read
+	resyncWindow = 64 << 10
+)
+
+// RepairReport tells what Repair did
+type RepairReport struct {
+	Files []RepairedFile // the data files that were rewritten
+}
+
+// RepairedFile is a data file rewritten by Repair
+type RepairedFile struct {
+	FileID  uint32
+	Records int            // records salvaged, batch and commit records included
+	Dropped []CorruptRange // the parts of the old file that were left out
+	Backup  string         // the old file
+}
+
+// Repair salvages what it can from damaged data files of the store at path,
+// which must not be open. Every data file is read like Open does, but on a
+// record that cannot be read Repair looks byte by byte for the next offset
+// where a record reads back whole and matches its checksums, and carries on
+// from there. A file where something had to be skipped is rewritten in the
+// current format with the records found, the old one is kept next to it with
+// a ".corrupt" suffix, or ".corrupt.N" if an earlier repair already kept one.
+// Files without damage are left alone.
+func Repair(path string, opts ...Option) (*RepairReport, error) {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	if options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	path = strings.TrimSuffix(path, "/")
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	path += "/"
+	lock, err := acquireLock(path, false, options.FileMode)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+	if err := recoverMerge(path); err != nil {
+		return nil, err
+	}
+	repairPath := path + repairDirName + "/"
+	if err := os.RemoveAll(repairPath); err != nil {
+		return nil, err
+	}
+
+	b := &Bitcask{Path: path, opts: options}
+	fileIDs, err := ScanDir(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &RepairReport{}
+	for _, fileID := range fileIDs {
+		repaired, err := b.repairFile(fileID, repairPath)
+		if err != nil {
+			return nil, err
+		}
+		if repaired != nil {
+			report.Files = append(report.Files, *repaired)
+		}
+	}
+	if err := os.RemoveAll(repairPath); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// repairFile rewrites data file fileID if it is damaged, it returns nil if it
+// is not
+func (b *Bitcask) repairFile(fileID uint32, repairPath string) (*RepairedFile, error) {
+	f := b.newFile(fileID, b.Path)
+	f.ReadOnly = true
+	if err := f.OpenFile(); err != nil {
+		return nil, err
+	}
+	defer f.CloseFile()
+	entries, dropped := f.salvageEntries()
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(repairPath, b.opts.DirMode); err != nil {
+		return nil, err
+	}
+	out := b.newFile(fileID, repairPath)
+	if err := out.OpenFile(); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		value, err := f.Read(entry.ValuePos, entry.ValueSize)
+		if err != nil {
+			out.CloseFile()
+			return nil, err
+		}
+		rec := newRecord(entry.TimeStamp, entry.Key, 0, value, entry.Flags, entry.Expiry)
+		if _, err := out.AppendRecord(rec); err != nil {
+			out.CloseFile()
+			return nil, err
+		}
+	}
+	if err := out.Sync(); err != nil {
+		out.CloseFile()
+		return nil, err
+	}
+	if err := out.CloseFile(); err != nil {
+		return nil, err
+	}
+
+	// the hint points into the old file, then the old file is kept under
+	// another name before the new one replaces it
+	if err := RemoveHintFile(b.Path, fileID); err != nil {
+		return nil, err
+	}
+	// the backup of an earlier repair may be the only copy of the original
+	// records, it is never replaced
+	backup := f.Name() + corruptSuffix
+	for i := 1; ; i++ {
+		err := os.Link(f.Name(), backup)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("repair: keeping %s: %w", f.Name(), err)
+		}
+		backup = fmt.Sprintf("%s%s.%d", f.Name(), corruptSuffix, i)
+	}
+	if err := out.Rename(f.Name()); err != nil {
+		return nil, err
+	}
+	if err := syncDir(b.Path); err != nil {
+		return nil, err
+	}
+	return &RepairedFile{FileID: fileID, Records: len(entries), Dropped: dropped, Backup: backup}, nil
+}
+
+// salvageEntries reads every record of the file, batch and commit records
+// included. Past a record that cannot be read it goes on from the next offset
+// where one can, see resync. It returns the entries read and the ranges
+// skipped.
+//
+// A batch a skipped range cuts into must not look committed: the batch
+// records read before the range are dropped, and those read after it are
+// only kept with their commit record if it counts exactly them.
+func (f *File) salvageEntries() ([]*Entry, []CorruptRange) {
+	var entries []*Entry
+	var dropped []CorruptRange
+	// batch records read since the last skipped range, before any other
+	var pending []*Entry
+	afterGap := false
+	f.CurrentPos = f.dataStart()
+	for f.CurrentPos < f.FileSize {
+		entry, err := f.ReadEntry()
+		if err != nil {
+			for n := len(entries); n > 0 && entries[n-1].Flags&FlagBatch != 0; n-- {
+				entries = entries[:n-1]
+			}
+			pending, afterGap = nil, true
+			start := f.CurrentPos
+			next := f.resync(start + 1)
+			dropped = append(dropped, CorruptRange{FileID: f.FileID, Offset: start, Size: next - start})
+			f.CurrentPos = next
+			continue
+		}
+		if afterGap {
+			switch {
+			case entry.Flags&FlagBatch != 0:
+				pending = append(pending, entry)
+				continue
+			case entry.Flags&FlagBatchCommit != 0:
+				if len(entry.Key) == 4 && int(binary.BigEndian.Uint32(entry.Key)) == len(pending) {
+					entries = append(entries, pending...)
+					entries = append(entries, entry)
+				}
+				pending, afterGap = nil, false
+				continue
+			}
+			pending, afterGap = nil, false
+		}
+		entries = append(entries, entry)
+	}
+	return entries, dropped
+}
+
+// resync returns the first offset from offset on where a whole record can be
+// read: its header is plausible, and matches its checksum for formats that
+// have one, and the CRC of the record matches. It returns FileSize if there is
+// none. The file is scanned a window at a time, the record is only read at
+// offsets whose header passes plausibleHeader.
+func (f *File) resync(offset uint64) uint64 {
+	rs := recordSize(f.Version)
+	// the window is read with room for the longest header at its last offset
+	slack := headerSize(f.Version, FlagExpiry)
+	for offset+rs <= f.FileSize {
+		n := f.FileSize - offset
+		if n > resyncWindow+slack {
+			n = resyncWindow + slack
+		}
+		buf, err := f.Read(offset, n)
+		if err != nil {
+			return f.FileSize
+		}
+		// the offsets whose fixed header is in buf
+		positions := n - rs + 1
+		if positions > resyncWindow {
+			positions = resyncWindow
+		}
+		for i := uint64(0); i < positions; i++ {
+			if !f.plausibleHeader(offset+i, buf[i:]) {
+				continue
+			}
+			f.CurrentPos = offset + i
+			if _, err := f.ReadEntry(); err == nil {
+				return offset + i
+			}
+		}
+		offset += positions
+	}
+	return f.FileSize
+}
+
+// plausibleHeader reports whether buf, read at offset, starts with a record
+// header that ReadEntry would accept: known flags only, and checkHeader passes
+// on it. buf holds at least the fixed header, a header running past its end
+// runs past the end of the file.
+func (f *File) plausibleHeader(offset uint64, buf []byte) bool {
+	rs := recordSize(f.Version)
+	header := decodeHeader(f.Version, buf[:rs])
+	if header.Flags&^(FlagTombstone|FlagBatch|FlagBatchCommit|FlagExpiry) != 0 {
+		return false
+	}
+	hs := headerSize(f.Version, header.Flags)
+	if hs > uint64(len(buf)) {
+		return false
+	}
+	return f.checkHeader(offset, buf[:hs], header) == nil
+}
@@ -0,0 +1,245 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Repair(t *testing.T) {
+	// three 41 byte records per file
+	const recSize = RecordSize + 5 + 7
+	dir := t.TempDir()
+	b, err := Open(dir, WithMaxFileSize(FileHeaderSize+3*recSize))
+	assert.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	// the writer holds the directory
+	_, err = Repair(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.NoError(t, b.Close())
+
+	report, err := Repair(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Files)
+
+	// a bad value in file 1, and garbage between two records of file 2
+	data, err := os.ReadFile(dir + "/1.data")
+	assert.NoError(t, err)
+	data[FileHeaderSize+2*recSize-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(dir+"/1.data", data, 0644))
+	data, err = os.ReadFile(dir + "/2.data")
+	assert.NoError(t, err)
+	garbage := []byte("garbage")
+	data = append(data[:FileHeaderSize+recSize], append(garbage, data[FileHeaderSize+recSize:]...)...)
+	assert.NoError(t, os.WriteFile(dir+"/2.data", data, 0644))
+	// Open trusts the hints, without them it finds the damage
+	assert.NoError(t, RemoveHintFile(dir+"/", 1))
+	assert.NoError(t, RemoveHintFile(dir+"/", 2))
+	_, err = Open(dir)
+	assert.ErrorIs(t, err, ErrChecksum)
+	old, err := os.ReadFile(dir + "/1.data")
+	assert.NoError(t, err)
+
+	report, err = Repair(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []RepairedFile{
+		{
+			FileID:  1,
+			Records: 2,
+			Dropped: []CorruptRange{{FileID: 1, Offset: FileHeaderSize + recSize, Size: recSize}},
+			Backup:  dir + "/1.data.corrupt",
+		},
+		{
+			FileID:  2,
+			Records: 3,
+			Dropped: []CorruptRange{{FileID: 2, Offset: FileHeaderSize + recSize, Size: uint64(len(garbage))}},
+			Backup:  dir + "/2.data.corrupt",
+		},
+	}, report.Files)
+	backup, err := os.ReadFile(dir + "/1.data.corrupt")
+	assert.NoError(t, err)
+	assert.Equal(t, old, backup)
+	_, err = os.Stat(dir + "/" + repairDirName)
+	assert.True(t, os.IsNotExist(err))
+
+	verified, err := Verify(dir)
+	assert.NoError(t, err)
+	assert.True(t, verified.OK)
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.Equal(t, 7, b.Len())
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		v, err := b.Get([]byte(key))
+		if i == 1 {
+			assert.ErrorIs(t, err, ErrNotFound)
+		} else if assert.NoError(t, err, key) {
+			assert.Equal(t, fmt.Sprintf("value_%d", i), string(v.Value))
+		}
+	}
+}
+
+func Test_RepairLegacy(t *testing.T) {
+	dir := t.TempDir()
+	ts := time.Now().UnixNano()
+	writeLegacyFile(t, dir, 1,
+		NewRecord(ts, []byte("a"), 0, []byte("value_a")),
+		NewRecord(ts, []byte("b"), 0, []byte("value_b")),
+		NewExpiringRecord(ts, []byte("c"), 0, []byte("value_c"), now().Add(time.Hour).Unix()),
+	)
+	// a huge ValueSize in the header of the first record
+	data, err := os.ReadFile(dir + "/1.data")
+	assert.NoError(t, err)
+	data[12] = 0xff
+	assert.NoError(t, os.WriteFile(dir+"/1.data", data, 0644))
+
+	report, err := Repair(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, report.Files, 1) {
+		assert.Equal(t, 2, report.Files[0].Records)
+		assert.Equal(t, []CorruptRange{{FileID: 1, Offset: 0, Size: legacyRecordSize + 1 + 7}}, report.Files[0].Dropped)
+	}
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.Equal(t, FormatVersion, b.CurrentFile.Version)
+	assert.Equal(t, 2, b.Len())
+	v, err := b.Get([]byte("c"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "value_c", string(v.Value))
+		assert.NotZero(t, v.Expiry)
+	}
+}
+
+func Test_RepairLargeGap(t *testing.T) {
+	// garbage over several resync windows between two records
+	dir := t.TempDir()
+	recs := []*Record{
+		NewRecord(1, []byte("a"), 0, []byte("value_a")),
+		NewRecord(1, []byte("b"), 0, []byte("value_b")),
+		NewRecord(1, []byte("c"), 0, []byte("value_c")),
+	}
+	garbage := make([]byte, 2*resyncWindow+1000)
+	rand.New(rand.NewSource(1)).Read(garbage)
+	data := encodeFileHeader(dataMagic, FormatVersion)
+	data = append(data, encodeRecord(FormatVersion, recs[0])...)
+	data = append(data, garbage...)
+	for _, rec := range recs[1:] {
+		data = append(data, encodeRecord(FormatVersion, rec)...)
+	}
+	assert.NoError(t, os.WriteFile(dir+"/1.data", data, 0644))
+
+	report, err := Repair(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, report.Files, 1) {
+		assert.Equal(t, 3, report.Files[0].Records)
+		start := uint64(FileHeaderSize + len(encodeRecord(FormatVersion, recs[0])))
+		assert.Equal(t, []CorruptRange{{FileID: 1, Offset: start, Size: uint64(len(garbage))}}, report.Files[0].Dropped)
+	}
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		v, err := b.Get([]byte(key))
+		if assert.NoError(t, err, key) {
+			assert.Equal(t, "value_"+key, string(v.Value))
+		}
+	}
+}
+
+func Test_RepairBatch(t *testing.T) {
+	dir := t.TempDir()
+	batch := func(keys ...string) []*Record {
+		var recs []*Record
+		for _, key := range keys {
+			recs = append(recs, NewRecordWithFlags(1, []byte(key), 0, []byte("value_"+key), FlagBatch))
+		}
+		count := make([]byte, 4)
+		binary.BigEndian.PutUint32(count, uint32(len(keys)))
+		return append(recs, NewRecordWithFlags(1, count, 0, nil, FlagBatchCommit))
+	}
+	plain := func(key string) []*Record {
+		return []*Record{NewRecord(1, []byte(key), 0, []byte("value_"+key))}
+	}
+	var recs []*Record
+	for _, part := range [][]*Record{
+		plain("c"),
+		batch("a1", "a2", "a3"),
+		batch("b1", "b2"),
+		plain("x"),
+		batch("e1", "e2"),
+		plain("d"),
+	} {
+		recs = append(recs, part...)
+	}
+	// the commit of batch a and the first record of batch b are lost, then x
+	// right before the whole batch e
+	corrupt := map[int]bool{4: true, 5: true, 8: true}
+	data := encodeFileHeader(dataMagic, FormatVersion)
+	for i, rec := range recs {
+		enc := encodeRecord(FormatVersion, rec)
+		if corrupt[i] {
+			enc[len(enc)-1] ^= 0xff
+		}
+		data = append(data, enc...)
+	}
+	assert.NoError(t, os.WriteFile(dir+"/1.data", data, 0644))
+
+	report, err := Repair(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, report.Files, 1) {
+		assert.Len(t, report.Files[0].Dropped, 2)
+	}
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	defer b.Close()
+	for _, key := range []string{"c", "e1", "e2", "d"} {
+		v, err := b.Get([]byte(key))
+		if assert.NoError(t, err, key) {
+			assert.Equal(t, "value_"+key, string(v.Value))
+		}
+	}
+	for _, key := range []string{"a1", "a2", "a3", "b1", "b2", "x"} {
+		_, err := b.Get([]byte(key))
+		assert.ErrorIs(t, err, ErrNotFound, key)
+	}
+	assert.Equal(t, 4, b.Len())
+}
+
+func Test_RepairKeepsBackups(t *testing.T) {
+	dir := t.TempDir()
+	damage := func(key string) []byte {
+		writeLegacyFile(t, dir, 1,
+			NewRecord(1, []byte(key), 0, []byte("value")),
+			NewRecord(1, []byte("b"), 0, []byte("value")),
+		)
+		data, err := os.ReadFile(dir + "/1.data")
+		assert.NoError(t, err)
+		data[len(data)-1] ^= 0xff
+		assert.NoError(t, os.WriteFile(dir+"/1.data", data, 0644))
+		return data
+	}
+	first := damage("a")
+	report, err := Repair(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, dir+"/1.data.corrupt", report.Files[0].Backup)
+
+	// the backup of the first repair is left as it is
+	second := damage("c")
+	report, err = Repair(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, dir+"/1.data.corrupt.1", report.Files[0].Backup)
+	for name, want := range map[string][]byte{"/1.data.corrupt": first, "/1.data.corrupt.1": second} {
+		data, err := os.ReadFile(dir + name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, data, name)
+	}
+}
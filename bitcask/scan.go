@@ -0,0 +1,234 @@
+package bitcask
+
+import "bytes"
+
+// scanChunk is the number of entries a forward iterator copies out of the
+// memDB at a time
+const scanChunk = 256
+
+type ScanOptions struct {
+	KeysOnly bool // do not read the values
+	Limit    int  // stop after Limit keys, 0 means no limit
+	Reverse  bool // iterate from the last key down
+}
+
+type ScanOption func(*ScanOptions)
+
+func WithKeysOnly() ScanOption {
+	return func(o *ScanOptions) {
+		o.KeysOnly = true
+	}
+}
+
+func WithLimit(limit int) ScanOption {
+	return func(o *ScanOptions) {
+		o.Limit = limit
+	}
+}
+
+func WithReverse() ScanOption {
+	return func(o *ScanOptions) {
+		o.Reverse = true
+	}
+}
+
+// Iterator walks the keys of a Scan in order. The keys are copied out of the
+// memDB a chunk at a time so writes are not blocked while iterating, and the
+// value of each key is read from its data file when Next reaches it. A key
+// deleted after it was copied is skipped, one overwritten yields the new
+// value.
+//
+//	it := b.Scan(start, end)
+//	for it.Next() {
+//		use(it.Key(), it.Value())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	b     *Bitcask
+	opts  ScanOptions
+	start []byte
+	end   []byte // exclusive, nil means no upper bound
+
+	entries []Entry // copies of the memDB entries not yet returned
+	pos     int
+	more    bool // forward only: the memDB may have keys after entries
+	count   int
+
+	key   []byte
+	value []byte
+	err   error
+}
+
+// Scan returns an iterator over the keys in [start, end). A nil start begins
+// at the first key and a nil end runs to the last one.
+func (b *Bitcask) Scan(start, end []byte, opts ...ScanOption) *Iterator {
+	it := &Iterator{
+		b:     b,
+		start: start,
+		end:   end,
+		more:  true,
+	}
+	for _, opt := range opts {
+		opt(&it.opts)
+	}
+	if it.opts.Reverse {
+		it.loadAll()
+	}
+	return it
+}
+
+// ScanPrefix returns an iterator over the keys starting with prefix
+func (b *Bitcask) ScanPrefix(prefix []byte, opts ...ScanOption) *Iterator {
+	return b.Scan(prefix, prefixEnd(prefix), opts...)
+}
+
+// prefixEnd returns the first key after all the keys starting with prefix,
+// or nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (it *Iterator) inRange(key []byte) bool {
+	return it.end == nil || bytes.Compare(key, it.end) < 0
+}
+
+// loadChunk copies the next entries after the last returned key
+func (it *Iterator) loadChunk() {
+	from := it.start
+	if len(it.entries) > 0 {
+		from = it.entries[len(it.entries)-1].Key
+	}
+	skipFrom := len(it.entries) > 0
+	it.entries = it.entries[:0]
+	it.pos = 0
+
+	it.b.mu.RLock()
+	defer it.b.mu.RUnlock()
+	if it.b.closed {
+		it.err = ErrClosed
+		return
+	}
+	iter := it.b.memDB.RangeIterator(NewTmpEntry(from), nil)
+	for len(it.entries) < scanChunk {
+		e := iter.Next()
+		if e == nil || !it.inRange(e.Key) {
+			it.more = false
+			return
+		}
+		if skipFrom && bytes.Equal(e.Key, from) || e.Expired(now()) {
+			continue
+		}
+		it.entries = append(it.entries, *e)
+	}
+}
+
+// loadAll copies every entry in range for a reverse scan, keeping only the
+// last Limit ones when a limit is set
+func (it *Iterator) loadAll() {
+	it.b.mu.RLock()
+	defer it.b.mu.RUnlock()
+	it.more = false
+	if it.b.closed {
+		it.err = ErrClosed
+		return
+	}
+	iter := it.b.memDB.RangeIterator(NewTmpEntry(it.start), nil)
+	for e := iter.Next(); e != nil && it.inRange(e.Key); e = iter.Next() {
+		if e.Expired(now()) {
+			continue
+		}
+		it.entries = append(it.entries, *e)
+		if it.opts.Limit > 0 && len(it.entries) > 2*it.opts.Limit {
+			it.entries = append(it.entries[:0], it.entries[len(it.entries)-it.opts.Limit:]...)
+		}
+	}
+	// reverse in place
+	for i, j := 0, len(it.entries)-1; i < j; i, j = i+1, j-1 {
+		it.entries[i], it.entries[j] = it.entries[j], it.entries[i]
+	}
+}
+
+// Next advances to the next key, it returns false at the end of the range or
+// on error. Iterating a closed store fails with ErrClosed.
+func (it *Iterator) Next() bool {
+	for it.err == nil {
+		if it.opts.Limit > 0 && it.count >= it.opts.Limit {
+			return false
+		}
+		if it.pos >= len(it.entries) {
+			if !it.more {
+				return false
+			}
+			it.loadChunk()
+			continue
+		}
+		e := &it.entries[it.pos]
+		it.pos++
+		if it.opts.KeysOnly {
+			it.key, it.value = e.Key, nil
+			it.count++
+			return true
+		}
+		value, ok, err := it.b.readCurrent(e.Key)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if !ok {
+			// deleted since it was copied
+			continue
+		}
+		it.key, it.value = e.Key, value
+		it.count++
+		return true
+	}
+	return false
+}
+
+// Key returns the current key, it must not be modified
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value of the current key, nil with WithKeysOnly
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the entries held by the iterator
+func (it *Iterator) Close() {
+	it.entries = nil
+	it.more = false
+	it.pos = 0
+}
+
+// readCurrent reads the current value of key, ok is false if it is gone
+func (b *Bitcask) readCurrent(key []byte) ([]byte, bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, false, ErrClosed
+	}
+	e := b.memDB.Search(NewTmpEntry(key))
+	if e == nil || e.Expired(now()) {
+		return nil, false, nil
+	}
+	rec, err := b.readRecord(e, false)
+	if err != nil {
+		return nil, false, err
+	}
+	return rec.Value, true, nil
+}
@@ -0,0 +1,97 @@
+package bitcask
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(it *Iterator) (keys, values []string) {
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+		values = append(values, string(it.Value()))
+	}
+	return keys, values
+}
+
+func Test_Scan(t *testing.T) {
+	b, err := Open(t.TempDir())
+	assert.NoError(t, err)
+	defer b.Close()
+	// more keys than a chunk
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%04d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	assert.NoError(t, b.Delete([]byte("key_0500")))
+
+	it := b.Scan(nil, nil)
+	keys, values := collect(it)
+	assert.NoError(t, it.Err())
+	assert.Len(t, keys, 999)
+	assert.Equal(t, "key_0000", keys[0])
+	assert.Equal(t, "value_0", values[0])
+	assert.Equal(t, "key_0999", keys[998])
+	assert.NotContains(t, keys, "key_0500")
+
+	keys, values = collect(b.Scan([]byte("key_0010"), []byte("key_0013")))
+	assert.Equal(t, []string{"key_0010", "key_0011", "key_0012"}, keys)
+	assert.Equal(t, []string{"value_10", "value_11", "value_12"}, values)
+
+	keys, _ = collect(b.Scan([]byte("key_0998"), nil, WithReverse()))
+	assert.Equal(t, []string{"key_0999", "key_0998"}, keys)
+
+	keys, values = collect(b.Scan(nil, nil, WithReverse(), WithLimit(3), WithKeysOnly()))
+	assert.Equal(t, []string{"key_0999", "key_0998", "key_0997"}, keys)
+	assert.Equal(t, []string{"", "", ""}, values)
+
+	keys, _ = collect(b.Scan(nil, nil, WithLimit(300)))
+	assert.Len(t, keys, 300)
+	assert.Equal(t, "key_0299", keys[299])
+}
+
+func Test_ScanPrefix(t *testing.T) {
+	b, err := Open(t.TempDir())
+	assert.NoError(t, err)
+	defer b.Close()
+	for _, k := range []string{"a", "ab", "abc", "abd", "ac", "b", "ab\xff", "ab\xff\xff"} {
+		assert.NoError(t, b.Put([]byte(k), []byte(k)))
+	}
+	keys, values := collect(b.ScanPrefix([]byte("ab")))
+	assert.Equal(t, []string{"ab", "abc", "abd", "ab\xff", "ab\xff\xff"}, keys)
+	assert.Equal(t, keys, values)
+
+	keys, _ = collect(b.ScanPrefix([]byte("ab\xff")))
+	assert.Equal(t, []string{"ab\xff", "ab\xff\xff"}, keys)
+
+	keys, _ = collect(b.ScanPrefix([]byte("ab"), WithReverse(), WithLimit(2)))
+	assert.Equal(t, []string{"ab\xff\xff", "ab\xff"}, keys)
+
+	keys, _ = collect(b.ScanPrefix([]byte("z")))
+	assert.Empty(t, keys)
+}
+
+func Test_ScanConcurrentWrites(t *testing.T) {
+	b, err := Open(t.TempDir())
+	assert.NoError(t, err)
+	defer b.Close()
+	for i := 0; i < 600; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%04d", i)), []byte("old")))
+	}
+	it := b.Scan(nil, nil)
+	assert.True(t, it.Next())
+	// writes made while iterating are seen by the keys not reached yet
+	assert.NoError(t, b.Delete([]byte("key_0001")))
+	assert.NoError(t, b.Put([]byte("key_0599"), []byte("new")))
+	keys, values := collect(it)
+	assert.NotContains(t, keys, "key_0001")
+	assert.Equal(t, "key_0599", keys[len(keys)-1])
+	assert.Equal(t, "new", values[len(values)-1])
+}
+
+func Test_PrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("ac"), prefixEnd([]byte("ab")))
+	assert.Equal(t, []byte("b"), prefixEnd([]byte("a\xff")))
+	assert.Nil(t, prefixEnd([]byte("\xff\xff")))
+	assert.Nil(t, prefixEnd(nil))
+}
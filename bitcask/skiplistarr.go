@@ -3,6 +3,7 @@ package bitcask
 import (
 	"math/rand"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -126,7 +127,11 @@ type SkipListIterator struct {
 }
 
 // SkipListArr 结构体：数组跳表
+// Insert/Delete/Search 可以并发调用：查找之间互不阻塞，插入和删除串行执行。
+// 迭代器不加锁，迭代期间不能修改跳表。
 type SkipListArr struct {
+	mu     sync.RWMutex
+	length int        // 元素个数
 	level  int        // 当前最高层数
 	header *Node      // 哨兵节点
 	rand   *rand.Rand // 随机数生成器
@@ -161,6 +166,9 @@ func (s *SkipListArr) randomLevel() int {
 
 // Insert 插入操作
 func (s *SkipListArr) Insert(key *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.length++
 	update := make([]*Node, MAX_LEVEL+1)
 	current := s.header
 
@@ -222,6 +230,8 @@ func (s *SkipListArr) Insert(key *Entry) {
 
 // Search 查找操作
 func (s *SkipListArr) Search(key *Entry) *Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	current := s.header
 
 	// 从最高层开始查找
@@ -240,8 +250,31 @@ func (s *SkipListArr) Search(key *Entry) *Entry {
 	return nil // 未找到
 }
 
-// RangeIterator 返回位于 [start, end] 的迭代器
+// Len 返回元素个数
+func (s *SkipListArr) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.length
+}
+
+// Walk 按顺序遍历底层链表，fn 返回 false 时停止
+// 遍历期间持有读锁，fn 中不能修改跳表
+func (s *SkipListArr) Walk(fn func(e *Entry) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for node := s.header.forward[0]; node != nil; node = node.forward[0] {
+		for _, e := range node.array {
+			if !fn(e) {
+				return
+			}
+		}
+	}
+}
+
+// RangeIterator 返回位于 [start, end] 的迭代器，end 为 nil 时没有上界
 func (s *SkipListArr) RangeIterator(start, end *Entry) *SkipListIterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	current := s.header
 
 	// 1. 从最高层找到 >= start 的起始节点
@@ -285,7 +318,7 @@ func (it *SkipListIterator) Next() *Entry {
 			continue
 		}
 
-		if entry.Greater(it.end) {
+		if it.end != nil && entry.Greater(it.end) {
 			// 超过范围，迭代结束
 			it.currentNode = nil
 			return nil
@@ -298,6 +331,8 @@ func (it *SkipListIterator) Next() *Entry {
 
 // Delete 从跳表中删除指定的 key
 func (s *SkipListArr) Delete(key *Entry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	update := make([]*Node, MAX_LEVEL+1)
 	current := s.header
 
@@ -309,7 +344,14 @@ func (s *SkipListArr) Delete(key *Entry) bool {
 		update[i] = current // update[i] 是 key 所在节点的前驱节点
 	}
 
-	// current 此时是底层链表中 First() < key 的最后一个节点 (key 所在节点的前一个节点)
+	// current 此时是底层链表中 First() < key 的最后一个节点
+	// key 不是节点的第一个元素时就在 current 内部，删除后 current 仍非空，不需要调整指针
+	if current != s.header && current.DeleteFromArray(key) {
+		s.length--
+		return true
+	}
+
+	// 否则 key 只可能是下一个节点的第一个元素 (current 是其前驱节点)
 	current = current.forward[0] // current 现在指向可能包含 key 的节点
 
 	if current == nil {
@@ -321,6 +363,7 @@ func (s *SkipListArr) Delete(key *Entry) bool {
 	if !res {
 		return false // 节点存在，但 key 不在内部数组中
 	}
+	s.length--
 
 	// 如果删除成功且节点为空，则从跳表中移除该节点
 	if current.IsEmpty() {
@@ -2,6 +2,7 @@ package bitcask
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 )
 
@@ -15,3 +16,74 @@ func Test_base(t *testing.T) {
 	fmt.Println("Search 10:", key)
 	fmt.Println(skipArr)
 }
+
+func Test_SkipListDelete(t *testing.T) {
+	skipArr := NewSkipListArr()
+	for i := 0; i < 1000; i++ {
+		skipArr.Insert(NewTmpEntry([]byte(fmt.Sprintf("%04d", i))))
+	}
+	for i := 0; i < 1000; i += 3 {
+		e := skipArr.Search(NewTmpEntry([]byte(fmt.Sprintf("%04d", i))))
+		if e == nil || !skipArr.Delete(e) {
+			t.Fatalf("delete %04d failed", i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		e := skipArr.Search(NewTmpEntry([]byte(fmt.Sprintf("%04d", i))))
+		if (i%3 == 0) != (e == nil) {
+			t.Fatalf("search %04d after delete: %v", i, e)
+		}
+	}
+}
+
+func Test_SkipListConcurrent(t *testing.T) {
+	skipArr := NewSkipListArr()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				skipArr.Insert(NewTmpEntry([]byte(fmt.Sprintf("%d_%04d", w, i))))
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				skipArr.Search(NewTmpEntry([]byte(fmt.Sprintf("%d_%04d", w, i))))
+			}
+		}(w)
+	}
+	wg.Wait()
+	for w := 0; w < 4; w++ {
+		for i := 0; i < 500; i++ {
+			if skipArr.Search(NewTmpEntry([]byte(fmt.Sprintf("%d_%04d", w, i)))) == nil {
+				t.Fatalf("missing %d_%04d", w, i)
+			}
+		}
+	}
+}
+
+func Test_SkipListWalk(t *testing.T) {
+	skipArr := NewSkipListArr()
+	for i := 999; i >= 0; i-- {
+		skipArr.Insert(NewTmpEntry([]byte(fmt.Sprintf("%04d", i))))
+	}
+	skipArr.Delete(skipArr.Search(NewTmpEntry([]byte("0500"))))
+	if skipArr.Len() != 999 {
+		t.Fatalf("len %d", skipArr.Len())
+	}
+	var prev []byte
+	n := 0
+	skipArr.Walk(func(e *Entry) bool {
+		if prev != nil && string(prev) >= string(e.Key) {
+			t.Fatalf("out of order %s after %s", e.Key, prev)
+		}
+		prev = e.Key
+		n++
+		return true
+	})
+	if n != 999 {
+		t.Fatalf("walked %d", n)
+	}
+}
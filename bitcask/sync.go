@@ -0,0 +1,116 @@
+package bitcask
+
+import (
+	"sync"
+	"time"
+)
+
+// groupCommit lets concurrent writers share one fsync: every write gets a
+// sequence number, the first writer to wait becomes the leader and syncs
+// everything written so far, the others wait for it.
+type groupCommit struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	written uint64 // sequence of the last write
+	synced  uint64 // every write up to this sequence is on disk
+	syncing bool
+}
+
+func newGroupCommit() *groupCommit {
+	g := &groupCommit{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// add records a write, it must be called with Bitcask.mu held so the
+// sequence follows the order of the data file
+func (g *groupCommit) add() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.written++
+	return g.written
+}
+
+// wait returns once write seq is synced, calling sync if no other writer is
+// syncing already
+func (g *groupCommit) wait(seq uint64, sync func() error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.synced < seq {
+		if g.syncing {
+			g.cond.Wait()
+			continue
+		}
+		g.syncing = true
+		target := g.written
+		g.mu.Unlock()
+		err := sync()
+		g.mu.Lock()
+		g.syncing = false
+		if err == nil {
+			g.synced = target
+		}
+		g.cond.Broadcast()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync flushes the current file to disk
+func (b *Bitcask) Sync() error {
+	b.mu.RLock()
+	f, closed := b.CurrentFile, b.closed
+	b.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if f == nil || b.opts.ReadOnly {
+		return nil
+	}
+	b.unsynced.Store(0)
+	return f.Sync()
+}
+
+// afterWrite applies the sync policy to a write of n bytes, it is called
+// with mu held. For group commit it returns the sequence to pass to
+// waitCommit once mu is released.
+func (b *Bitcask) afterWrite(n int) (uint64, error) {
+	switch b.opts.SyncPolicy {
+	case SyncAlways:
+		return 0, b.CurrentFile.Sync()
+	case SyncBytes:
+		if b.unsynced.Add(uint64(n)) >= b.opts.SyncBytes {
+			b.unsynced.Store(0)
+			return 0, b.CurrentFile.Sync()
+		}
+	case SyncGroupCommit:
+		return b.group.add(), nil
+	}
+	return 0, nil
+}
+
+// waitCommit waits for a group commit, it must be called without mu held
+func (b *Bitcask) waitCommit(seq uint64) error {
+	if seq == 0 {
+		return nil
+	}
+	return b.group.wait(seq, b.Sync)
+}
+
+// syncLoop fsyncs the current file every interval until stop is closed
+func (b *Bitcask) syncLoop(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// a failed sync is retried on the next tick
+			b.Sync()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package bitcask
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SyncPolicies(t *testing.T) {
+	policies := map[string]Option{
+		"always":   WithSyncPolicy(SyncAlways),
+		"never":    WithSyncPolicy(SyncNever),
+		"interval": WithSyncInterval(time.Millisecond),
+		"bytes":    WithSyncBytes(100),
+		"group":    WithGroupCommit(),
+	}
+	for name, opt := range policies {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			b, err := Open(dir, opt)
+			assert.NoError(t, err)
+			var wg sync.WaitGroup
+			for w := 0; w < 4; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d_%d", w, i)), []byte("value")))
+					}
+				}(w)
+			}
+			wg.Wait()
+			assert.NoError(t, b.Sync())
+			assert.NoError(t, b.Close())
+
+			b, err = Open(dir)
+			assert.NoError(t, err)
+			for w := 0; w < 4; w++ {
+				for i := 0; i < 50; i++ {
+					v, err := b.Get([]byte(fmt.Sprintf("key_%d_%d", w, i)))
+					assert.NoError(t, err)
+					assert.Equal(t, "value", string(v.Value))
+				}
+			}
+			assert.NoError(t, b.Close())
+		})
+	}
+}
+
+func Test_SyncOptionsValidate(t *testing.T) {
+	_, err := Open(t.TempDir(), WithSyncInterval(0))
+	assert.Error(t, err)
+	_, err = Open(t.TempDir(), WithSyncBytes(0))
+	assert.Error(t, err)
+}
+
+func Test_GroupCommit(t *testing.T) {
+	const writers = 32
+	g := newGroupCommit()
+	// the first sync holds until every writer has written and is waiting,
+	// all of them are then synced by at most one more
+	var added sync.WaitGroup
+	added.Add(writers)
+	var syncs int32
+	syncFn := func() error {
+		if atomic.AddInt32(&syncs, 1) == 1 {
+			added.Wait()
+		}
+		return nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seq := g.add()
+			added.Done()
+			assert.NoError(t, g.wait(seq, syncFn))
+			g.mu.Lock()
+			assert.GreaterOrEqual(t, g.synced, seq)
+			g.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	// concurrent writers share fsyncs
+	assert.LessOrEqual(t, atomic.LoadInt32(&syncs), int32(2))
+	assert.Greater(t, atomic.LoadInt32(&syncs), int32(0))
+}
+
+func Test_CloseSyncInterval(t *testing.T) {
+	// the sync loop is stopped once, by the Close that wins
+	b, err := Open(t.TempDir(), WithSyncInterval(time.Millisecond))
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("key"), []byte("value")))
+	var wg sync.WaitGroup
+	var ok int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.Close(); err == nil {
+				atomic.AddInt32(&ok, 1)
+			} else {
+				assert.ErrorIs(t, err, ErrClosed)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ok))
+}
@@ -0,0 +1,134 @@
+package bitcask
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setNow moves the expiry clock by offset until the test ends
+func setNow(t *testing.T, offset time.Duration) {
+	old := now
+	now = func() time.Time { return time.Now().Add(offset) }
+	t.Cleanup(func() { now = old })
+}
+
+func Test_PutWithTTL(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.Error(t, b.PutWithTTL([]byte("key"), []byte("value"), 0))
+	assert.NoError(t, b.Put([]byte("shadowed"), []byte("old")))
+	assert.NoError(t, b.PutWithTTL([]byte("shadowed"), []byte("new"), time.Minute))
+	assert.NoError(t, b.PutWithTTL([]byte("session"), []byte("value"), time.Minute))
+	assert.NoError(t, b.PutWithTTL([]byte("renewed"), []byte("value"), time.Minute))
+	assert.NoError(t, b.Put([]byte("renewed"), []byte("forever")))
+	assert.NoError(t, b.Put([]byte("plain"), []byte("value")))
+
+	v, err := b.Get([]byte("session"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(v.Value))
+
+	setNow(t, 2*time.Minute)
+	v, err = b.Get([]byte("session"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, v)
+	v, err = b.Get([]byte("renewed"))
+	assert.NoError(t, err)
+	assert.Equal(t, "forever", string(v.Value))
+	keys, _ := collect(b.Scan(nil, nil))
+	assert.Equal(t, []string{"plain", "renewed"}, keys)
+	assert.Len(t, b.Keys(), 2)
+	assert.NoError(t, b.Close())
+
+	// replay skips expired records, they also hide older values of the key
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, b.Len())
+	v, err = b.Get([]byte("shadowed"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, v)
+	assert.NoError(t, b.Close())
+}
+
+func Test_TTLRoundsUp(t *testing.T) {
+	old := now
+	t.Cleanup(func() { now = old })
+	clock := time.Unix(1700000000, 950*int64(time.Millisecond))
+	now = func() time.Time { return clock }
+
+	b, err := Open(t.TempDir())
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.NoError(t, b.PutWithTTL([]byte("key"), []byte("value"), 500*time.Millisecond))
+	// the ttl ends at .45 of the next second, the key must still be there
+	clock = clock.Add(450 * time.Millisecond)
+	_, err = b.Get([]byte("key"))
+	assert.NoError(t, err)
+	clock = time.Unix(1700000002, 0)
+	_, err = b.Get([]byte("key"))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_TTLReplay(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.PutWithTTL([]byte("key"), []byte("value"), time.Minute))
+	b.rotate(MaxFileSize)
+	assert.NoError(t, b.Close())
+
+	// the expiry survives a restart, through the hint file as well
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	v, err := b.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(v.Value))
+	assert.NoError(t, b.Close())
+
+	entries, err := ReadHintFile(b.Path, 1)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.NotZero(t, entries[0].Expiry)
+}
+
+func Test_MergeDropsExpired(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.PutWithTTL([]byte("expiring"), []byte("value"), time.Minute))
+	assert.NoError(t, b.PutWithTTL([]byte("live"), []byte("value"), time.Hour))
+	assert.NoError(t, b.Put([]byte("plain"), []byte("value")))
+	b.rotate(MaxFileSize)
+	assert.NoError(t, b.Put([]byte("active"), []byte("value")))
+
+	setNow(t, 2*time.Minute)
+	assert.NoError(t, b.Merge())
+	entries, err := ReadHintFile(b.Path, 1)
+	assert.NoError(t, err)
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, string(e.Key))
+	}
+	assert.ElementsMatch(t, []string{"live", "plain"}, keys)
+	// the expired key is gone from the memDB too, its file id is reused
+	assert.Equal(t, 3, b.Len())
+
+	v, err := b.Get([]byte("live"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(v.Value))
+	assert.NoError(t, b.Close())
+
+	// the merged record keeps its expiry
+	b, err = Open(dir)
+	assert.NoError(t, err)
+	v, err = b.Get([]byte("live"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(v.Value))
+	setNow(t, 2*time.Hour)
+	v, err = b.Get([]byte("live"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, v)
+	assert.NoError(t, b.Close())
+}
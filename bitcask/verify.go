@@ -0,0 +1,190 @@
+package bitcask
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// VerifyReport is the result of Verify, it marshals to JSON
+type VerifyReport struct {
+	Path   string       `json:"path"`
+	OK     bool         `json:"ok"`
+	Files  []FileReport `json:"files"`
+	Errors []string     `json:"errors,omitempty"` // problems not tied to a data file
+}
+
+// FileReport tells what Verify found in one data file
+type FileReport struct {
+	FileID     uint32        `json:"file_id"`
+	Version    uint16        `json:"version"`
+	Size       uint64        `json:"size"`
+	Records    int           `json:"records"` // valid records, tombstones and batch records included
+	Tombstones int           `json:"tombstones"`
+	Hint       string        `json:"hint"` // "ok", "missing", "invalid" (ignored by Open) or "mismatch"
+	Errors     []VerifyError `json:"errors,omitempty"`
+}
+
+// VerifyError is a problem at an offset of a data file. The records after a
+// corrupt one are not checked.
+type VerifyError struct {
+	Offset int64  `json:"offset"`
+	Torn   bool   `json:"torn,omitempty"` // a torn record at the end of the file, see File.ReadEntries
+	Err    string `json:"error"`
+}
+
+// Verify checks the store at path without changing anything in it: every
+// record of every data file is read and checked against its header and CRC,
+// batches must be followed by their commit records, format versions must not
+// go back as the file ids go up, and hint files must match the records of
+// their data files. A file rewritten by Repair, which keeps the old one with a
+// ".corrupt" suffix, is in the current format whatever its neighbours are and
+// is left out of the version order. It returns an error only if the check
+// cannot be done, for instance while a writer has the store open. Problems
+// found go in the report, which is OK if there are none but a torn record at
+// the end of the last file, as Open cuts it off, or hint files Open would not
+// use.
+func Verify(path string) (*VerifyReport, error) {
+	path = strings.TrimSuffix(path, "/")
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	path += "/"
+	lock, err := acquireLock(path, true, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	report := &VerifyReport{Path: path, OK: true, Files: []FileReport{}}
+	if pendingMerge(path) {
+		report.Errors = append(report.Errors, "a completed merge has not been moved into place")
+	}
+	fileIDs, err := ScanDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var version uint16
+	for i, fileID := range fileIDs {
+		fr, err := verifyFile(path, fileID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(NewFile(fileID, path).Name() + corruptSuffix); err != nil {
+			if fr.Version < version {
+				fr.Errors = append(fr.Errors, VerifyError{
+					Err: fmt.Sprintf("format version %d after version %d", fr.Version, version),
+				})
+			}
+			version = fr.Version
+		}
+		for _, e := range fr.Errors {
+			if !e.Torn || i != len(fileIDs)-1 {
+				report.OK = false
+			}
+		}
+		if fr.Hint == "mismatch" {
+			report.OK = false
+		}
+		report.Files = append(report.Files, fr)
+	}
+
+	// hint files without their data file
+	hints, err := filepath.Glob(path + "*.hint")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range hints {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".hint"), 10, 32)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(NewFile(uint32(id), path).Name()); os.IsNotExist(err) {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s has no data file", filepath.Base(name)))
+		}
+	}
+	if len(report.Errors) > 0 {
+		report.OK = false
+	}
+	return report, nil
+}
+
+// verifyFile reads every record of data file fileID in path
+func verifyFile(path string, fileID uint32) (FileReport, error) {
+	f := NewFile(fileID, path)
+	f.ReadOnly = true
+	fr := FileReport{FileID: fileID}
+	if err := f.OpenFile(); err != nil {
+		var fileErr *FileError
+		if errors.As(err, &fileErr) {
+			fr.Errors = append(fr.Errors, VerifyError{Err: err.Error()})
+			return fr, nil
+		}
+		return fr, err
+	}
+	defer f.CloseFile()
+	fr.Version = f.Version
+	fr.Size = f.FileSize
+
+	batch := 0
+	f.CurrentPos = f.dataStart()
+	for f.CurrentPos < f.FileSize {
+		offset := f.CurrentPos
+		entry, err := f.ReadEntry()
+		if err != nil {
+			fr.Errors = append(fr.Errors, VerifyError{Offset: int64(offset), Torn: f.tornAt(offset), Err: err.Error()})
+			break
+		}
+		fr.Records++
+		switch {
+		case entry.Flags&FlagBatchCommit != 0:
+			if len(entry.Key) != 4 || int(binary.BigEndian.Uint32(entry.Key)) > batch {
+				fr.Errors = append(fr.Errors, VerifyError{Offset: int64(offset), Err: fmt.Sprintf(
+					"batch commit record does not follow its %d batch records", batch)})
+			}
+			batch = 0
+		case entry.Flags&FlagBatch != 0:
+			batch++
+		default:
+			batch = 0
+			if entry.IsTombstone() {
+				fr.Tombstones++
+			}
+		}
+	}
+
+	hint, err := ReadHintFile(path, fileID)
+	switch {
+	case os.IsNotExist(err):
+		fr.Hint = "missing"
+	case err != nil:
+		fr.Hint = "invalid"
+	default:
+		fr.Hint = "ok"
+		// the hint holds what replay would take from the data file
+		entries, err := f.ReadEntries()
+		if err != nil || !sameEntries(hint, entries) {
+			fr.Hint = "mismatch"
+		}
+	}
+	return fr, nil
+}
+
+func sameEntries(a, b []*Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].FileID != b[i].FileID || a[i].ValuePos != b[i].ValuePos || a[i].ValueSize != b[i].ValueSize ||
+			a[i].TimeStamp != b[i].TimeStamp || a[i].Flags != b[i].Flags || a[i].Expiry != b[i].Expiry ||
+			!bytes.Equal(a[i].Key, b[i].Key) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package bitcask
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Verify(t *testing.T) {
+	// three 41 byte records per file
+	const recSize = RecordSize + 5 + 7
+	dir := t.TempDir()
+	b, err := Open(dir, WithMaxFileSize(FileHeaderSize+3*recSize))
+	assert.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, b.Put([]byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i))))
+	}
+	assert.NoError(t, b.Delete([]byte("key_0")))
+	// the writer holds the directory
+	_, err = Verify(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.NoError(t, b.Close())
+
+	before := dirState(t, dir)
+	report, err := Verify(dir)
+	assert.NoError(t, err)
+	assert.True(t, report.OK)
+	assert.Empty(t, report.Errors)
+	if assert.Len(t, report.Files, 3) {
+		assert.Equal(t, FileReport{FileID: 1, Version: FormatVersion, Size: FileHeaderSize + 3*recSize, Records: 3, Hint: "ok"}, report.Files[0])
+		assert.Equal(t, "ok", report.Files[1].Hint)
+		assert.Equal(t, 3, report.Files[2].Records)
+		assert.Equal(t, 1, report.Files[2].Tombstones)
+		assert.Equal(t, "missing", report.Files[2].Hint)
+	}
+	assert.Equal(t, before, dirState(t, dir))
+	data, err := json.Marshal(report)
+	assert.NoError(t, err)
+	var decoded VerifyReport
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *report, decoded)
+
+	// a torn record at the end of the last file is repaired by Open
+	fd, err := os.OpenFile(dir+"/3.data", os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = fd.Write(make([]byte, 10))
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+	report, err = Verify(dir)
+	assert.NoError(t, err)
+	assert.True(t, report.OK)
+	if assert.Len(t, report.Files[2].Errors, 1) {
+		assert.True(t, report.Files[2].Errors[0].Torn)
+		assert.Equal(t, int64(report.Files[2].Size-10), report.Files[2].Errors[0].Offset)
+	}
+
+	// a corrupt record in a sealed file is not
+	fd, err = os.OpenFile(dir+"/2.data", os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = fd.WriteAt([]byte{0xff}, FileHeaderSize+recSize+RecordSize)
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+	report, err = Verify(dir)
+	assert.NoError(t, err)
+	assert.False(t, report.OK)
+	assert.Equal(t, 1, report.Files[1].Records)
+	assert.Equal(t, []VerifyError{{Offset: FileHeaderSize + recSize,
+		Err: "data file 2 at offset 49: bitcask: checksum mismatch"}}, report.Files[1].Errors)
+	assert.Equal(t, "mismatch", report.Files[1].Hint)
+
+	// a hint needs its data file, one for another file is not used
+	assert.NoError(t, os.Remove(dir+"/2.data"))
+	assert.NoError(t, os.Rename(dir+"/1.hint", dir+"/3.hint"))
+	report, err = Verify(dir)
+	assert.NoError(t, err)
+	assert.False(t, report.OK)
+	assert.Equal(t, "invalid", report.Files[1].Hint)
+	assert.Equal(t, []string{"2.hint has no data file"}, report.Errors)
+}
+
+func Test_VerifyVersionOrder(t *testing.T) {
+	dir := t.TempDir()
+	b, err := Open(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Put([]byte("a"), []byte("value_a")))
+	assert.NoError(t, b.Close())
+	// an older format after the current one was not written by this package
+	writeLegacyFile(t, dir, 2, NewRecord(1, []byte("b"), 0, []byte("value_b")))
+	report, err := Verify(dir)
+	assert.NoError(t, err)
+	assert.False(t, report.OK)
+	assert.Equal(t, []VerifyError{{Err: fmt.Sprintf("format version %d after version %d", FormatLegacy, FormatVersion)}},
+		report.Files[1].Errors)
+
+	// Repair rewrites a damaged legacy file in the current format
+	dir = t.TempDir()
+	writeLegacyFile(t, dir, 1,
+		NewRecord(1, []byte("a"), 0, []byte("value_a")),
+		NewRecord(1, []byte("b"), 0, []byte("value_b")),
+	)
+	writeLegacyFile(t, dir, 2, NewRecord(1, []byte("c"), 0, []byte("value_c")))
+	data, err := os.ReadFile(dir + "/1.data")
+	assert.NoError(t, err)
+	data[12] = 0xff
+	assert.NoError(t, os.WriteFile(dir+"/1.data", data, 0644))
+	_, err = Repair(dir)
+	assert.NoError(t, err)
+	report, err = Verify(dir)
+	assert.NoError(t, err)
+	assert.True(t, report.OK, "%+v", report)
+	assert.Equal(t, FormatVersion, report.Files[0].Version)
+	assert.Equal(t, FormatLegacy, report.Files[1].Version)
+}
@@ -1,11 +1,115 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"os"
+
+	"github.com/acekingke/simplebitcask/bitcask"
 )
 
+const usage = `usage: simplebitcask <command> [flags] <dir>
+
+commands:
+  migrate   rewrite a data directory in the current file format
+  verify    check a data directory and print a JSON report
+  repair    salvage the records of damaged data files
+`
+
 func main() {
-	// 测试不同规模
-	fmt.Println("测试不同规模")
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "migrate":
+		err = migrate(os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2:])
+	case "repair":
+		err = repair(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "simplebitcask %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	maxFileSize := fs.Uint64("max-file-size", bitcask.MaxFileSize, "size at which a new data file is started")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: simplebitcask migrate [flags] <dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	report, err := bitcask.Migrate(fs.Arg(0), bitcask.WithMaxFileSize(*maxFileSize))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migrated %d records from %d files, %d bytes\n", report.Records, report.Files, report.Bytes)
+	fmt.Printf("the original files are in %s\n", report.BackupPath)
+	return nil
+}
+
+// verify prints the report of bitcask.Verify and exits with status 1 if it
+// found problems
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: simplebitcask verify <dir>")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	report, err := bitcask.Verify(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if !report.OK {
+		os.Exit(1)
+	}
+	return nil
+}
 
+func repair(args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: simplebitcask repair <dir>")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	report, err := bitcask.Repair(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(report.Files) == 0 {
+		fmt.Println("no damaged data files")
+	}
+	for _, f := range report.Files {
+		fmt.Printf("data file %d: salvaged %d records, the old file is %s\n", f.FileID, f.Records, f.Backup)
+		for _, r := range f.Dropped {
+			fmt.Printf("  dropped %d bytes at offset %d\n", r.Size, r.Offset)
+		}
+	}
+	return nil
 }